@@ -15,7 +15,7 @@ var (
 
 var _ = BeforeSuite(func() {
 	var err error
-	pathToMain, err = gexec.Build("github.com/pivotal-cf/replicator")
+	pathToMain, err = gexec.Build("github.com/dawu415/replicator")
 	Expect(err).NotTo(HaveOccurred())
 })
 
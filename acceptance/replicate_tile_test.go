@@ -21,6 +21,7 @@ adding: releases/some-release.tgz
 done`
 
 const WRT_2012_OUTPUT = `replicating %s to %s
+warning: p-windows-runtime is deprecated, use pas-windows instead
 adding: metadata/
 adding: metadata/p-windows-runtime.yml
 adding: migrations/
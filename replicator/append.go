@@ -0,0 +1,80 @@
+package replicator
+
+import (
+	"archive/zip"
+	"errors"
+	"path"
+	"time"
+)
+
+// AppendTo writes the replicated members of config.Path into dstTileZip,
+// under prefix, instead of into a tile of their own. config.Output is not
+// used. The caller owns dstTileZip and must close it.
+//
+// archive/zip does not refuse duplicate names, so a member whose prefixed
+// name matches one already in dstTileZip is written a second time rather
+// than reported. Use a prefix no other content in the archive shares.
+//
+// NameExists is consulted and, with VerifySignature, the source signature is
+// checked as Replicate does, but there is no tile of its own to verify
+// afterwards. DryRun is refused. Output, VerifyOutput, SkipOutputCheck,
+// WriteManifest, MetadataOutput, WriteReleasesBOM and AuditWriter concern
+// that tile and are ignored.
+func (t TileReplicator) AppendTo(dstTileZip *zip.Writer, prefix string, config ApplicationConfig) (ReplicationResult, error) {
+	result := ReplicationResult{
+		Source:  config.Path,
+		Started: time.Now(),
+	}
+
+	err := t.appendTo(dstTileZip, prefix, config, &result)
+	result.Duration = time.Since(result.Started)
+
+	return result, err
+}
+
+func (t TileReplicator) appendTo(dstTileZip *zip.Writer, prefix string, config ApplicationConfig, result *ReplicationResult) error {
+	if err := config.validate(false); err != nil {
+		return err
+	}
+	if config.DryRun {
+		return errors.New("AppendTo cannot do a dry run, use Replicate")
+	}
+
+	config, err := withResolvedName(config)
+	if err != nil {
+		return err
+	}
+	result.Name = config.Name
+
+	ctx, cancel := replicationContext(config)
+	defer cancel()
+
+	err = t.verifySourceSignature(config)
+	if err != nil {
+		return err
+	}
+
+	if prefix != "" {
+		config.PathPrefix = path.Join(prefix, config.PathPrefix)
+	}
+
+	srcTileZip, err := zip.OpenReader(config.Path)
+	if err != nil {
+		return errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	metadata, err := t.readMetadata(&srcTileZip.Reader, config)
+	if err != nil {
+		return err
+	}
+	result.TileName = metadata.tileName
+	result.ProductName = metadata.productName
+
+	err = checkNameExists(metadata, config)
+	if err != nil {
+		return err
+	}
+
+	return t.writeMembers(ctx, dstTileZip, &srcTileZip.Reader, metadata, config, result)
+}
@@ -0,0 +1,136 @@
+package replicator_test
+
+import (
+	"archive/zip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("AppendTo", func() {
+	var (
+		pathToCombined string
+		combined       *os.File
+		zw             *zip.Writer
+	)
+
+	BeforeEach(func() {
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToCombined = filepath.Join(tempDir, "combined.zip")
+
+		combined, err = os.Create(pathToCombined)
+		Expect(err).NotTo(HaveOccurred())
+
+		zw = zip.NewWriter(combined)
+		w, err := zw.Create("README")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = w.Write([]byte("some combined artifact"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		combined.Close()
+	})
+
+	It("appends the replicated members under the prefix", func() {
+		result, err := replicator.NewTileReplicator(&fakes.Logger{}).AppendTo(zw, "tiles/blue", replicator.ApplicationConfig{
+			Path: filepath.Join("..", "fixtures", "ist.pivotal"),
+			Name: "blue",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zw.Close()).To(Succeed())
+
+		Expect(result.ProductName).To(Equal("p-isolation-segment-blue"))
+		Expect(result.FilesCopied).To(Equal(6))
+
+		Expect(tileFileNames(pathToCombined)).To(Equal([]string{
+			"README",
+			"tiles/blue/metadata/",
+			"tiles/blue/migrations/",
+			"tiles/blue/releases/",
+			"tiles/blue/metadata/p-isolation-segment.yml",
+			"tiles/blue/migrations/v1/",
+			"tiles/blue/releases/some-release.tgz",
+		}))
+		Expect(readTileFile(pathToCombined, "README")).To(Equal("some combined artifact"))
+		Expect(readTileFile(pathToCombined, "tiles/blue/metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-blue"))
+	})
+
+	It("appends external artifacts under the prefix", func() {
+		artifact, err := ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = artifact.Write([]byte("some external artifact"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(artifact.Close()).To(Succeed())
+
+		_, err = replicator.NewTileReplicator(&fakes.Logger{}).AppendTo(zw, "tiles/blue", replicator.ApplicationConfig{
+			Path:                     filepath.Join("..", "fixtures", "ist.pivotal"),
+			Name:                     "blue",
+			IncludeExternalArtifacts: map[string]string{"README": artifact.Name()},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zw.Close()).To(Succeed())
+
+		Expect(readTileFile(pathToCombined, "README")).To(Equal("some combined artifact"))
+		Expect(readTileFile(pathToCombined, "tiles/blue/README")).To(Equal("some external artifact"))
+	})
+
+	It("returns an error for an invalid config", func() {
+		_, err := replicator.NewTileReplicator(&fakes.Logger{}).AppendTo(zw, "tiles/blue", replicator.ApplicationConfig{
+			Path: filepath.Join("..", "fixtures", "ist.pivotal"),
+		})
+		Expect(err).To(MatchError("name must not be empty"))
+
+		_, err = replicator.NewTileReplicator(&fakes.Logger{}).AppendTo(zw, "tiles/blue", replicator.ApplicationConfig{
+			Path:    filepath.Join("..", "fixtures", "ist.pivotal"),
+			Name:    "blue",
+			Workers: -1,
+		})
+		Expect(err).To(MatchError("workers must not be negative"))
+	})
+
+	It("honors the checks Replicate makes before writing", func() {
+		config := replicator.ApplicationConfig{
+			Path: filepath.Join("..", "fixtures", "ist.pivotal"),
+			Name: "blue",
+			NameExists: func(productName string) (bool, error) {
+				return productName == "p-isolation-segment-blue", nil
+			},
+		}
+		_, err := replicator.NewTileReplicator(&fakes.Logger{}).AppendTo(zw, "tiles/blue", config)
+		Expect(err).To(MatchError("a product named p-isolation-segment-blue already exists"))
+
+		verifier := &fakes.SignatureVerifier{}
+		verifier.VerifyReturns(errors.New("bad signature"))
+		config.NameExists = nil
+		config.VerifySignature = true
+		_, err = replicator.NewTileReplicator(&fakes.Logger{}, replicator.WithSignatureVerifier(verifier)).AppendTo(zw, "tiles/blue", config)
+		Expect(err).To(MatchError("signature of " + config.Path + " is invalid: bad signature"))
+
+		config.VerifySignature = false
+		config.DryRun = true
+		_, err = replicator.NewTileReplicator(&fakes.Logger{}).AppendTo(zw, "tiles/blue", config)
+		Expect(err).To(MatchError("AppendTo cannot do a dry run, use Replicate"))
+
+		Expect(zw.Close()).To(Succeed())
+		Expect(tileFileNames(pathToCombined)).To(Equal([]string{"README"}))
+	})
+
+	It("stops when the timeout passes", func() {
+		_, err := replicator.NewTileReplicator(&fakes.Logger{}).AppendTo(zw, "tiles/blue", replicator.ApplicationConfig{
+			Path:    filepath.Join("..", "fixtures", "ist.pivotal"),
+			Name:    "blue",
+			Timeout: time.Nanosecond,
+		})
+		Expect(err).To(MatchError("replication did not finish within 1ns"))
+	})
+})
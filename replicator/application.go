@@ -1,14 +1,321 @@
 package replicator
 
+import (
+	"io"
+	"os"
+	"time"
+)
+
 type Application struct {
 	argParser      argParser
 	tileReplicator tileReplicator
 }
 
+// OriginalNameDefaults is a policy for property defaults that mention the
+// original product name.
+type OriginalNameDefaults int
+
+const (
+	// KeepOriginalNameDefaults leaves the defaults as they are.
+	KeepOriginalNameDefaults OriginalNameDefaults = iota
+	// ClearOriginalNameDefaults removes the defaults, so operators must
+	// set the properties themselves.
+	ClearOriginalNameDefaults
+	// RenameOriginalNameDefaults replaces the original name in the
+	// defaults with the new product name.
+	RenameOriginalNameDefaults
+)
+
 type ApplicationConfig struct {
-	Name   string
-	Path   string
-	Output string
+	Name      string
+	Path      string
+	Output    string
+	ExpandEnv bool
+	Quiet     bool
+
+	// OutputExtension is used when Output is a directory and the file name
+	// is derived from the new product name. It defaults to ".pivotal".
+	OutputExtension string
+
+	// RenameJobTypes limits which isolation segment job types are renamed.
+	// All of them are renamed when it is empty.
+	RenameJobTypes []string
+
+	// PathPrefix relocates every member of the duplicate under the given
+	// directory.
+	PathPrefix string
+
+	// KeepRuntimeConfigs stops the mongodb handler from emptying
+	// runtime_configs, so the duplicate does not depend on the original tile.
+	KeepRuntimeConfigs bool
+
+	// FileNameTransform renames members as they are copied. The metadata
+	// file is never renamed.
+	FileNameTransform func(original string) string
+
+	// RequireReplaced lists tokens that must not appear anywhere in the
+	// transformed metadata.
+	RequireReplaced []string
+
+	// Replacements are applied on top of the built-in renames.
+	Replacements []ReplacementRule
+
+	// StripComments removes full-line comments from the YAML members that
+	// Replacements rewrite. The metadata never keeps its comments, since it
+	// is parsed and marshaled again.
+	StripComments bool
+
+	// GlobalSubstitutions are applied to the metadata, in order, after the
+	// built-in renames and the metadata Replacements. Each one sees the
+	// result of those before it.
+	GlobalSubstitutions []Substitution
+
+	// Transforms replaces the default pipeline of metadata transforms, see
+	// DefaultTransforms. RenameVariables, OverlayMetadataPath,
+	// KeepRuntimeConfigs and GlobalSubstitutions are then only used by the
+	// built-in transforms that read them.
+	Transforms []Transform
+
+	// AuditWriter receives a single JSON record for every replication.
+	AuditWriter io.Writer
+
+	// RenamePropertyBlueprints rewrites references to the original product
+	// name inside property_blueprints.
+	RenamePropertyBlueprints bool
+
+	// WriteManifest writes the SHA256 of every member, in sha256sum format,
+	// to Output + ".sha256".
+	WriteManifest bool
+
+	// WriteReleasesBOM lists the BOSH releases from the metadata, with their
+	// versions, in Output + ".releases.yml".
+	WriteReleasesBOM bool
+
+	// MetadataOutput, when set, also writes the replicated metadata there,
+	// exactly as it is written into the tile.
+	MetadataOutput string
+
+	// NameExists is consulted before anything is written, so callers can
+	// refuse product names already installed on their foundation.
+	NameExists func(productName string) (bool, error)
+
+	// ProductNamePrefix replaces the leading segment of the default product
+	// name, so p-isolation-segment becomes <prefix>-isolation-segment-<name>.
+	// Rename does not recognize duplicates named this way.
+	ProductNamePrefix string
+
+	// NameFunc and LabelFunc replace the default product name and label
+	// transforms.
+	NameFunc  func(original string, config ApplicationConfig) (string, error)
+	LabelFunc func(original string, config ApplicationConfig) string
+
+	// CheckDeploymentName fails the replication when the duplicate's BOSH
+	// deployment name, derived from its product name, would be the same as
+	// the original's. With the default product name it never is; this
+	// guards a NameFunc.
+	CheckDeploymentName bool
+
+	// AnnotateDescription adds the name to the metadata description, if it
+	// has one, so duplicates can be told apart in Ops Manager.
+	// DescriptionFunc, when set, replaces the default annotation.
+	AnnotateDescription bool
+	DescriptionFunc     func(original string, config ApplicationConfig) string
+
+	// RenameVariables suffixes the CredHub variables the tile declares, and
+	// the ((variable)) references to them, with the name.
+	RenameVariables bool
+
+	// Workers compresses non-metadata members on this many goroutines.
+	// Members are still written in their original order.
+	Workers int
+
+	// CopyBufferSize sets the buffer, in bytes, used to copy each member.
+	// io.Copy's default is used when it is zero.
+	CopyBufferSize int
+
+	// ClearSecretDefaults drops the defaults of secret and credential
+	// property blueprints so the duplicate does not share them. With a
+	// SecretProvider, secret properties instead default to the value the
+	// provider returns for their path, unless it returns an empty value.
+	// Those values are written into the duplicate's metadata, and so to
+	// MetadataOutput and ReplicateMetadataJSON, as plain text; only the
+	// DryRun diff redacts them.
+	ClearSecretDefaults bool
+
+	// OverlayMetadataPath names a YAML file deep-merged into the metadata
+	// after it has been transformed. Maps merge key by key; scalars and
+	// lists in the overlay replace the tile's.
+	OverlayMetadataPath string
+
+	// NormalizeModes writes every file as 0644 and every directory as 0755
+	// instead of copying the source modes.
+	NormalizeModes bool
+
+	// MetadataPath names the member holding the product metadata, for tiles
+	// whose layout the metadata/*.yml match gets wrong.
+	MetadataPath string
+
+	// NormalizeNames rewrites backslashes in member names to forward
+	// slashes and rejects names that are not valid UTF-8.
+	NormalizeNames bool
+
+	// SchemaPath names a JSON schema the transformed metadata must satisfy.
+	SchemaPath string
+
+	// OnUnsupportedTile is called with the name and raw metadata of a tile
+	// no handler supports, before Replicate returns its error.
+	OnUnsupportedTile func(tileName string, metadata []byte)
+
+	// AZMappings and NetworkMappings rename the AZs and networks pinned in
+	// the metadata, keyed by the original name. That includes the defaults
+	// of property blueprints that select an AZ or network, recognized by
+	// their type or by a name such as deployment_network.
+	AZMappings      map[string]string
+	NetworkMappings map[string]string
+
+	// OutputMode sets the permissions of the output tile exactly, ignoring
+	// the umask. Zero keeps os.Create's default.
+	OutputMode os.FileMode
+
+	// RequireConsistentName fails replication if the metadata's name is not
+	// the new product name after every handler and replacement has run.
+	RequireConsistentName bool
+
+	// Progress is called after each member is written with the source
+	// bytes copied so far and the tile's total uncompressed size.
+	Progress func(copied, total int64)
+
+	// OutputProgress is called as the output tile is written with the
+	// bytes of the archive written so far, compression and zip structures
+	// included.
+	OutputProgress func(written int64)
+
+	// VerifyOutput reads the written tile back with VerifyArchive before it
+	// is moved into place.
+	VerifyOutput bool
+
+	// SkipOutputCheck turns off the default check that the written tile
+	// opens and that its metadata parses.
+	SkipOutputCheck bool
+
+	// SlimMode leaves the release tarballs out of the duplicate, which then
+	// relies on the original tile's releases being uploaded.
+	SlimMode bool
+
+	// FileFilter, when set, is called with each member's name and
+	// uncompressed size. Members it returns false for are not copied. The
+	// metadata is always copied.
+	FileFilter func(name string, size int64) bool
+
+	// FailOnDependentDuplicate makes it an error to build a duplicate that
+	// requires the original tile, such as a slim duplicate or a mongodb
+	// duplicate without its runtime configs.
+	FailOnDependentDuplicate bool
+
+	// AutoName derives Name from the source tile's checksum and
+	// AutoNameSeed when Name is empty.
+	AutoName     bool
+	AutoNameSeed string
+
+	// Foundation is added to the default label after the name. With
+	// FoundationInName it is instead appended to Name, so it appears in the
+	// product name, job names and label alike. A LabelFunc or NameFunc
+	// still takes precedence.
+	Foundation       string
+	FoundationInName bool
+
+	// ExpectedTileName fails the replication if the source metadata has a
+	// different name.
+	ExpectedTileName string
+
+	// OnSourceOpened and OnDestinationCreated are called with how long the
+	// open took. OnDestinationClosed and OnSourceClosed are called with how
+	// long the file was open, whether or not the replication succeeded.
+	OnSourceOpened       func(path string, took time.Duration)
+	OnDestinationCreated func(path string, took time.Duration)
+	OnDestinationClosed  func(path string, open time.Duration)
+	OnSourceClosed       func(path string, open time.Duration)
+
+	// AllowedTiles, when set, restricts replication to these tile names.
+	AllowedTiles []string
+
+	// IncludeExternalArtifacts adds files from outside the source tile to
+	// the duplicate, keyed by member name. The names are prefixed and
+	// transformed like those of the tile's own members.
+	IncludeExternalArtifacts map[string]string
+
+	// LogEveryN logs only every Nth member added, followed by the number of
+	// members added, to keep the log of huge tiles readable.
+	LogEveryN int
+
+	// VerifySignature checks the signatures of the source tile and of the
+	// duplicate with the replicator's SignatureVerifier. Signed members
+	// other than the metadata are copied verbatim, so a signature that
+	// leaves the metadata out stays valid.
+	VerifySignature bool
+
+	// MaxMetadataBytes is the largest metadata member that will be read,
+	// 64 MiB when unset.
+	MaxMetadataBytes int64
+
+	// RenameSourceIDs suffixes source_id and origin values, which tag the
+	// tile's logs and metrics, with the name.
+	RenameSourceIDs bool
+
+	// RenameVMExtensions suffixes the names of the VM extensions the tile
+	// defines under vm_extensions, and the job types' references to them,
+	// so they do not collide with the original tile's.
+	RenameVMExtensions bool
+
+	// ReleaseVersionOverrides sets the version of releases in the metadata,
+	// by release name, for when a patched release will be substituted.
+	ReleaseVersionOverrides map[string]string
+
+	// OriginalNameDefaults decides what happens to property_blueprints
+	// defaults that mention the original product name.
+	OriginalNameDefaults OriginalNameDefaults
+
+	// WarnUnknownKeys logs a warning for each top-level metadata key the
+	// replicator does not know about. It does not change the output.
+	WarnUnknownKeys bool
+
+	// FailOnKeyLoss fails the replication, rather than logging a warning,
+	// when a top-level metadata key of the source is missing from the
+	// output.
+	FailOnKeyLoss bool
+
+	// EmbedReplicationInfo adds a replicated_from key to the metadata with
+	// the source tile's name, product_version, SHA-256 checksum and where
+	// it came from: its path, or the URL or directory given to ReplicateURL
+	// or ReplicateFromDir.
+	EmbedReplicationInfo bool
+
+	// DryRun logs a diff of the metadata changes instead of writing the
+	// output tile.
+	DryRun bool
+
+	// Timeout bounds the whole replication. When it passes, replication
+	// stops, even in the middle of copying or compressing a member, and the
+	// partial output is removed.
+	Timeout time.Duration
+
+	// MaxJobNameLength is the longest renamed job name allowed, 64 when
+	// unset. BOSH rejects longer names at deploy time.
+	MaxJobNameLength int
+
+	// ChunkSize and ResumeFrom are used by ReplicateToChunks.
+	ChunkSize  int
+	ResumeFrom int64
+
+	renaming bool
+	source   tileSource
+}
+
+// tileSource records where a tile replicated from a temporary file came
+// from.
+type tileSource struct {
+	location string
+	packed   bool
 }
 
 //go:generate counterfeiter -o ./fakes/arg_parser.go --fake-name ArgParser . argParser
@@ -23,9 +23,17 @@ func (ArgParser) Parse(args []string) (ApplicationConfig, error) {
 	flagSet := flag.NewFlagSet("replicator", flag.ExitOnError)
 	flagSet.StringVar(&cfg.Name, "name", "", "unique identifier for the duplicated tile. The only permitted special characters are hyphens, underscores, and spaces.")
 	flagSet.StringVar(&cfg.Path, "path", "", "path to source tile")
-	flagSet.StringVar(&cfg.Output, "output", "", "desired path for the duplicated tile")
+	flagSet.StringVar(&cfg.Output, "output", "", "desired path for the duplicated tile, or a directory to write it to")
+	flagSet.StringVar(&cfg.OutputExtension, "output-extension", "", "file extension used when --output is a directory (default .pivotal)")
+	flagSet.BoolVar(&cfg.ExpandEnv, "expand-env", false, "expand ${VAR} references in the name from the environment")
+	flagSet.BoolVar(&cfg.Quiet, "quiet", false, "do not log each file as it is added to the duplicated tile")
 	flagSet.Parse(args)
 
+	if cfg.ExpandEnv {
+		cfg.Name = os.ExpandEnv(cfg.Name)
+		cfg.ExpandEnv = false
+	}
+
 	var errMsgs []string
 
 	if cfg.Name == "" {
@@ -77,3 +85,23 @@ func parseName(name string) string {
 
 	return ""
 }
+
+// CanonicalName validates name as Parse validates --name, and returns the
+// form it takes in the product name. "Blue Foo" becomes "blue-foo".
+func CanonicalName(name string) (string, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", errors.New("name must not be empty")
+	}
+
+	if errMsg := parseName(name); errMsg != "" {
+		return "", errors.New(errMsg)
+	}
+
+	return canonicalName(name), nil
+}
+
+var nameSeparatorRegexp = regexp.MustCompile("[-_ ]")
+
+func canonicalName(name string) string {
+	return strings.ToLower(nameSeparatorRegexp.ReplaceAllLiteralString(name, "-"))
+}
@@ -3,6 +3,7 @@ package replicator_test
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 
 	"github.com/dawu415/replicator/replicator"
 
@@ -38,6 +39,37 @@ var _ = Describe("arg parser", func() {
 			}))
 		})
 
+		It("parses the quiet flag", func() {
+			config, err := argParser.Parse([]string{"--quiet", "--name", "some_name", "--path", pathToTile, "--output", "/path/to/output.pivotal"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(config.Quiet).To(BeTrue())
+		})
+
+		Context("when --expand-env is set", func() {
+			BeforeEach(func() {
+				os.Setenv("REPLICATOR_TEST_NAME", "blue")
+				os.Unsetenv("REPLICATOR_UNSET_NAME")
+			})
+
+			AfterEach(func() {
+				os.Unsetenv("REPLICATOR_TEST_NAME")
+			})
+
+			It("expands environment variables in the name", func() {
+				config, err := argParser.Parse([]string{"--expand-env", "--name", "${REPLICATOR_TEST_NAME}-1", "--path", pathToTile, "--output", "/path/to/output.pivotal"})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(config.Name).To(Equal("blue-1"))
+				Expect(config.ExpandEnv).To(BeFalse())
+			})
+
+			It("validates the expanded name", func() {
+				_, err := argParser.Parse([]string{"--expand-env", "--name", "${REPLICATOR_UNSET_NAME}", "--path", pathToTile, "--output", "/path/to/output.pivotal"})
+				Expect(err).To(MatchError("--name is a required argument"))
+			})
+		})
+
 		Context("error handling", func() {
 			Context("when the name is missing", func() {
 				It("returns an error", func() {
@@ -118,4 +150,26 @@ var _ = Describe("arg parser", func() {
 			})
 		})
 	})
+	Describe("CanonicalName", func() {
+		It("returns the name as it appears in the product name", func() {
+			Expect(replicator.CanonicalName("Blue Foo")).To(Equal("blue-foo"))
+			Expect(replicator.CanonicalName("blue_1")).To(Equal("blue-1"))
+			Expect(replicator.CanonicalName("red-2")).To(Equal("red-2"))
+		})
+
+		It("rejects an empty name", func() {
+			_, err := replicator.CanonicalName(" ")
+			Expect(err).To(MatchError("name must not be empty"))
+		})
+
+		It("rejects a name longer than 10 characters", func() {
+			_, err := replicator.CanonicalName("magenta-foo-1")
+			Expect(err).To(MatchError("Name cannot be longer than 10 characters"))
+		})
+
+		It("rejects illegal special characters", func() {
+			_, err := replicator.CanonicalName("$isoseg$")
+			Expect(err).To(MatchError("Invalid special characters in name: $isoseg$"))
+		})
+	})
 })
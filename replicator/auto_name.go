@@ -0,0 +1,30 @@
+package replicator
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// autoName derives a short name from the source tile's checksum and seed, so
+// replicating the same tile with the same seed always gives the same name.
+// The result is a letter followed by hex digits, which keeps it within the
+// 10 characters allowed for --name.
+func autoName(path, seed string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.New("could not open source zip file")
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", err // not tested
+	}
+	io.WriteString(hash, seed)
+
+	return fmt.Sprintf("r%x", hash.Sum(nil)[:4]), nil
+}
@@ -0,0 +1,107 @@
+package replicator_test
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+func BenchmarkReplicateSequential(b *testing.B) {
+	benchmarkReplicate(b, replicator.ApplicationConfig{})
+}
+
+func BenchmarkReplicateFourWorkers(b *testing.B) {
+	benchmarkReplicate(b, replicator.ApplicationConfig{Workers: 4})
+}
+
+func BenchmarkReplicateCopyBuffer32KB(b *testing.B) {
+	benchmarkReplicate(b, replicator.ApplicationConfig{CopyBufferSize: 32 << 10})
+}
+
+func BenchmarkReplicateCopyBuffer256KB(b *testing.B) {
+	benchmarkReplicate(b, replicator.ApplicationConfig{CopyBufferSize: 256 << 10})
+}
+
+func BenchmarkReplicateCopyBuffer1MB(b *testing.B) {
+	benchmarkReplicate(b, replicator.ApplicationConfig{CopyBufferSize: 1 << 20})
+}
+
+func BenchmarkReplicateCopyBuffer4MB(b *testing.B) {
+	benchmarkReplicate(b, replicator.ApplicationConfig{CopyBufferSize: 4 << 20})
+}
+
+func benchmarkReplicate(b *testing.B, config replicator.ApplicationConfig) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	config.Path = writeBenchmarkTile(b, tempDir, 8, 4<<20)
+	config.Output = filepath.Join(tempDir, "replicated-tile.pivotal")
+	config.Name = "bench"
+	config.Quiet = true
+
+	tileReplicator := replicator.NewTileReplicator(&fakes.Logger{})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := tileReplicator.Replicate(config)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// writeBenchmarkTile writes an isolation segment tile with releases members
+// of releaseSize bytes each, half random and half repetitive so that they
+// compress roughly like real release tarballs.
+func writeBenchmarkTile(b *testing.B, dir string, releases, releaseSize int) string {
+	pathToTile := filepath.Join(dir, "tile.pivotal")
+	f, err := os.Create(pathToTile)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("metadata/p-isolation-segment.yml")
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, err = w.Write([]byte("name: p-isolation-segment\nlabel: PCF Isolation Segment\n"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	random := rand.New(rand.NewSource(1))
+	contents := make([]byte, releaseSize)
+	for i := 0; i < releases; i++ {
+		random.Read(contents[:releaseSize/2])
+		for j := releaseSize / 2; j < releaseSize; j++ {
+			contents[j] = byte(j % 64)
+		}
+
+		w, err := zw.Create(filepath.Join("releases", string(rune('a'+i))+".tgz"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = w.Write(contents)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return pathToTile
+}
@@ -0,0 +1,46 @@
+package replicator
+
+import (
+	"fmt"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type releasesBOM struct {
+	Releases []bomRelease `yaml:"releases"`
+}
+
+type bomRelease struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	File    string `yaml:"file,omitempty"`
+}
+
+// marshalReleasesBOM lists the BOSH releases declared in the metadata
+// releases section.
+func marshalReleasesBOM(releases interface{}) ([]byte, error) {
+	bom := releasesBOM{Releases: []bomRelease{}}
+
+	list, _ := releases.([]interface{})
+	for _, release := range list {
+		release, ok := release.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+
+		bom.Releases = append(bom.Releases, bomRelease{
+			Name:    fmt.Sprintf("%v", release["name"]),
+			Version: fmt.Sprintf("%v", release["version"]),
+			File:    stringValue(release["file"]),
+		})
+	}
+
+	return yaml.Marshal(bom)
+}
+
+func stringValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,183 @@
+package replicator
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const defaultChunkSize = 8 << 20
+
+// ChunkWriter receives a replicated tile in order, one chunk at a time.
+// offset is the position of chunk in the tile. WriteChunk should retry
+// transient failures itself; an error it returns stops the replication.
+type ChunkWriter interface {
+	WriteChunk(offset int64, chunk []byte) error
+}
+
+// ReplicateToChunks streams the replicated tile to dst in chunks of
+// config.ChunkSize bytes, 8 MiB when unset, instead of writing
+// config.Output. When a chunk cannot be written, the returned result's Size
+// is the number of bytes dst accepted.
+//
+// A zip's central directory is written last and records the offset of
+// every member, so a partial tile cannot be read or appended to. To resume,
+// replicate again with config.ResumeFrom set to the accepted size: the tile
+// is produced again from the start and its first ResumeFrom bytes are not
+// sent. This relies on replication being reproducible, so the source,
+// config and options must not change in between. Checksum always covers
+// the whole tile. ResumeFrom must not be past the end of the tile.
+//
+// NameExists and the source signature are checked as AppendTo checks them,
+// and the same options are refused or ignored.
+func (t TileReplicator) ReplicateToChunks(dst ChunkWriter, config ApplicationConfig) (ReplicationResult, error) {
+	result := ReplicationResult{
+		Source:  config.Path,
+		Started: time.Now(),
+	}
+
+	chunks := &chunkWriter{dst: dst, size: config.ChunkSize, skip: config.ResumeFrom}
+	if chunks.size <= 0 {
+		chunks.size = defaultChunkSize
+	}
+
+	err := t.replicateToChunks(chunks, config, &result)
+	if err != nil {
+		result.Size = chunks.offset
+	}
+	result.Duration = time.Since(result.Started)
+
+	return result, err
+}
+
+func (t TileReplicator) replicateToChunks(chunks *chunkWriter, config ApplicationConfig, result *ReplicationResult) error {
+	if err := config.validate(false); err != nil {
+		return err
+	}
+	if config.DryRun {
+		return errors.New("ReplicateToChunks cannot do a dry run, use Replicate")
+	}
+
+	config, err := withResolvedName(config)
+	if err != nil {
+		return err
+	}
+	result.Name = config.Name
+
+	ctx, cancel := replicationContext(config)
+	defer cancel()
+
+	err = t.verifySourceSignature(config)
+	if err != nil {
+		return err
+	}
+
+	srcTileZip, err := zip.OpenReader(config.Path)
+	if err != nil {
+		return errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	metadata, err := t.readMetadata(&srcTileZip.Reader, config)
+	if err != nil {
+		return err
+	}
+	result.TileName = metadata.tileName
+	result.ProductName = metadata.productName
+
+	err = checkNameExists(metadata, config)
+	if err != nil {
+		return err
+	}
+
+	checksum := sha256.New()
+	size := &countingWriter{onWrite: config.OutputProgress}
+	dstTileZip := zip.NewWriter(io.MultiWriter(chunks, checksum, size))
+
+	err = t.writeMembers(ctx, dstTileZip, &srcTileZip.Reader, metadata, config, result)
+	if err != nil {
+		return err
+	}
+
+	err = dstTileZip.SetComment(srcTileZip.Reader.Comment)
+	if err != nil {
+		return err // not tested
+	}
+
+	err = dstTileZip.Close()
+	if err != nil {
+		return err
+	}
+
+	err = chunks.flush()
+	if err != nil {
+		return err
+	}
+
+	if config.ResumeFrom > size.n {
+		return fmt.Errorf("cannot resume from %d, the tile is %d bytes", config.ResumeFrom, size.n)
+	}
+
+	result.Size = size.n
+	result.Checksum = hex.EncodeToString(checksum.Sum(nil))
+
+	return nil
+}
+
+// chunkWriter buffers writes into chunks for a ChunkWriter, dropping the
+// first skip bytes.
+type chunkWriter struct {
+	dst    ChunkWriter
+	size   int
+	skip   int64
+	offset int64
+	buf    []byte
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if c.skip > 0 {
+		skipped := int64(len(p))
+		if skipped > c.skip {
+			skipped = c.skip
+		}
+		c.skip -= skipped
+		c.offset += skipped
+		p = p[skipped:]
+	}
+
+	c.buf = append(c.buf, p...)
+	for len(c.buf) >= c.size {
+		err := c.writeChunk(c.buf[:c.size])
+		if err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf[:0], c.buf[c.size:]...)
+	}
+
+	return n, nil
+}
+
+func (c *chunkWriter) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	err := c.writeChunk(c.buf)
+	c.buf = c.buf[:0]
+	return err
+}
+
+func (c *chunkWriter) writeChunk(chunk []byte) error {
+	err := c.dst.WriteChunk(c.offset, chunk)
+	if err != nil {
+		return fmt.Errorf("could not write %d bytes at offset %d: %s", len(chunk), c.offset, err)
+	}
+	c.offset += int64(len(chunk))
+
+	return nil
+}
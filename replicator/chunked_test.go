@@ -0,0 +1,161 @@
+package replicator_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+// flakyStorage fails every other write the first time it sees an offset.
+type flakyStorage struct {
+	contents bytes.Buffer
+	failed   map[int64]bool
+	failFrom int64
+}
+
+func (s *flakyStorage) write(offset int64, chunk []byte) error {
+	if s.failFrom > 0 && offset >= s.failFrom {
+		return errors.New("connection reset")
+	}
+	if !s.failed[offset] && len(s.failed)%2 == 0 {
+		s.failed[offset] = true
+		return errors.New("connection reset")
+	}
+	s.failed[offset] = true
+	Expect(offset).To(Equal(int64(s.contents.Len())))
+	s.contents.Write(chunk)
+	return nil
+}
+
+// retryingWriter retries each chunk a few times before giving up.
+type retryingWriter struct {
+	storage *flakyStorage
+}
+
+func (w retryingWriter) WriteChunk(offset int64, chunk []byte) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		err = w.storage.write(offset, chunk)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+var _ = Describe("ReplicateToChunks", func() {
+	var (
+		tileReplicator replicator.TileReplicator
+		config         replicator.ApplicationConfig
+		expected       []byte
+		storage        *flakyStorage
+	)
+
+	BeforeEach(func() {
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+		config = replicator.ApplicationConfig{
+			Path:      filepath.Join("..", "fixtures", "ist.pivotal"),
+			Name:      "blue",
+			Quiet:     true,
+			ChunkSize: 64,
+		}
+
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+		replicateConfig := config
+		replicateConfig.Output = pathToOutputTile
+		Expect(tileReplicator.Replicate(replicateConfig)).To(Succeed())
+
+		expected, err = ioutil.ReadFile(pathToOutputTile)
+		Expect(err).NotTo(HaveOccurred())
+
+		storage = &flakyStorage{failed: map[int64]bool{}}
+	})
+
+	It("streams the same tile Replicate writes through a writer that retries", func() {
+		result, err := tileReplicator.ReplicateToChunks(retryingWriter{storage: storage}, config)
+		Expect(err).NotTo(HaveOccurred())
+
+		checksum := sha256.Sum256(expected)
+		Expect(storage.contents.Bytes()).To(Equal(expected))
+		Expect(result.Size).To(Equal(int64(len(expected))))
+		Expect(result.Checksum).To(Equal(hex.EncodeToString(checksum[:])))
+		Expect(result.ProductName).To(Equal("p-isolation-segment-blue"))
+	})
+
+	It("stops when the timeout passes", func() {
+		config.Timeout = time.Nanosecond
+
+		_, err := tileReplicator.ReplicateToChunks(retryingWriter{storage: storage}, config)
+		Expect(err).To(MatchError("replication did not finish within 1ns"))
+		Expect(storage.contents.Len()).To(BeZero())
+	})
+
+	It("reports the bytes written so a failed stream can be resumed", func() {
+		storage.failFrom = 256
+
+		result, err := tileReplicator.ReplicateToChunks(retryingWriter{storage: storage}, config)
+		Expect(err).To(MatchError("could not write 64 bytes at offset 256: connection reset"))
+		Expect(result.Size).To(Equal(int64(256)))
+
+		storage.failFrom = 0
+		config.ResumeFrom = result.Size
+		result, err = tileReplicator.ReplicateToChunks(retryingWriter{storage: storage}, config)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(storage.contents.Bytes()).To(Equal(expected))
+		Expect(result.Size).To(Equal(int64(len(expected))))
+	})
+	It("honors the checks Replicate makes before writing", func() {
+		config.NameExists = func(productName string) (bool, error) {
+			return productName == "p-isolation-segment-blue", nil
+		}
+		_, err := tileReplicator.ReplicateToChunks(retryingWriter{storage: storage}, config)
+		Expect(err).To(MatchError("a product named p-isolation-segment-blue already exists"))
+
+		verifier := &fakes.SignatureVerifier{}
+		verifier.VerifyReturns(errors.New("bad signature"))
+		config.NameExists = nil
+		config.VerifySignature = true
+		_, err = replicator.NewTileReplicator(&fakes.Logger{}, replicator.WithSignatureVerifier(verifier)).ReplicateToChunks(retryingWriter{storage: storage}, config)
+		Expect(err).To(MatchError("signature of " + config.Path + " is invalid: bad signature"))
+
+		config.VerifySignature = false
+		config.DryRun = true
+		_, err = tileReplicator.ReplicateToChunks(retryingWriter{storage: storage}, config)
+		Expect(err).To(MatchError("ReplicateToChunks cannot do a dry run, use Replicate"))
+
+		Expect(storage.contents.Len()).To(BeZero())
+	})
+
+	Context("when ResumeFrom is out of range", func() {
+		It("returns an error for a negative offset", func() {
+			config.ResumeFrom = -1
+
+			_, err := tileReplicator.ReplicateToChunks(retryingWriter{storage: storage}, config)
+			Expect(err).To(MatchError("resume from must not be negative"))
+			Expect(storage.contents.Len()).To(BeZero())
+		})
+
+		It("returns an error for an offset past the end of the tile", func() {
+			config.ResumeFrom = int64(len(expected)) + 1
+
+			_, err := tileReplicator.ReplicateToChunks(retryingWriter{storage: storage}, config)
+			Expect(err).To(MatchError(fmt.Sprintf("cannot resume from %d, the tile is %d bytes", len(expected)+1, len(expected))))
+			Expect(storage.contents.Len()).To(BeZero())
+		})
+	})
+})
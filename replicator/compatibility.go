@@ -0,0 +1,109 @@
+package replicator
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxOpsManagerVersionKey is the metadata key declaring the newest Ops
+// Manager a tile supports.
+const maxOpsManagerVersionKey = "max_ops_manager_version"
+
+// CheckOpsManagerCompatibility returns an error if the tile at path cannot
+// be installed on the given Ops Manager version. Tiles declare the oldest
+// Ops Manager they support with metadata_version and may declare the newest
+// with max_ops_manager_version, which admits every patch of the version it
+// names: 2.10 admits 2.10.3. A bound the tile does not declare is not
+// checked.
+func CheckOpsManagerCompatibility(path, version string) error {
+	target, err := parseOpsManagerVersion(version)
+	if err != nil {
+		return err
+	}
+
+	srcTileZip, err := zip.OpenReader(path)
+	if err != nil {
+		return errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	member, contents, err := readMetadataFile(&srcTileZip.Reader, metadataRegexp, ApplicationConfig{})
+	if err != nil {
+		return err
+	}
+	if member == "" {
+		return fmt.Errorf("%s does not contain tile metadata", path)
+	}
+
+	metadata, err := parseProductMetadata(contents)
+	if err != nil {
+		return err
+	}
+
+	if metadataVersion, ok := metadata["metadata_version"]; ok {
+		minimum, err := parseOpsManagerVersion(fmt.Sprintf("%v", metadataVersion))
+		if err != nil {
+			return fmt.Errorf("tile metadata_version is invalid: %s", err)
+		}
+
+		if compareVersions(target, minimum) < 0 {
+			return fmt.Errorf("%s requires Ops Manager %v or later, target is %s", metadata["name"], metadataVersion, version)
+		}
+	}
+
+	if maxVersion, ok := metadata[maxOpsManagerVersionKey]; ok {
+		maximum, err := parseOpsManagerVersion(fmt.Sprintf("%v", maxVersion))
+		if err != nil {
+			return fmt.Errorf("tile %s is invalid: %s", maxOpsManagerVersionKey, err)
+		}
+
+		truncated := target
+		if len(truncated) > len(maximum) {
+			truncated = truncated[:len(maximum)]
+		}
+		if compareVersions(truncated, maximum) > 0 {
+			return fmt.Errorf("%s supports Ops Manager %v at most, target is %s", metadata["name"], maxVersion, version)
+		}
+	}
+
+	return nil
+}
+
+func parseOpsManagerVersion(version string) ([]int, error) {
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid Ops Manager version %s", version)
+		}
+		parts = append(parts, n)
+	}
+
+	return parts, nil
+}
+
+// compareVersions treats missing trailing components as zero, so 2.4 and
+// 2.4.0 are equal.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
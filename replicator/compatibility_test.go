@@ -0,0 +1,92 @@
+package replicator_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+)
+
+var _ = Describe("CheckOpsManagerCompatibility", func() {
+	var pathToTile string
+
+	BeforeEach(func() {
+		pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: `---
+name: pas-windows
+label: Pivotal Application Service for Windows
+metadata_version: "2.4"
+`})
+	})
+
+	It("accepts versions at or above the tile's metadata_version", func() {
+		Expect(replicator.CheckOpsManagerCompatibility(pathToTile, "2.4")).To(Succeed())
+		Expect(replicator.CheckOpsManagerCompatibility(pathToTile, "2.4.3")).To(Succeed())
+		Expect(replicator.CheckOpsManagerCompatibility(pathToTile, "2.10")).To(Succeed())
+	})
+
+	It("rejects older versions", func() {
+		err := replicator.CheckOpsManagerCompatibility(pathToTile, "2.3.9")
+		Expect(err).To(MatchError("pas-windows requires Ops Manager 2.4 or later, target is 2.3.9"))
+	})
+
+	Context("when the tile declares a max_ops_manager_version", func() {
+		BeforeEach(func() {
+			pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: `---
+name: pas-windows
+label: Pivotal Application Service for Windows
+metadata_version: "2.4"
+max_ops_manager_version: "2.10"
+`})
+		})
+
+		It("accepts versions within the range", func() {
+			Expect(replicator.CheckOpsManagerCompatibility(pathToTile, "2.4")).To(Succeed())
+			Expect(replicator.CheckOpsManagerCompatibility(pathToTile, "2.10")).To(Succeed())
+			Expect(replicator.CheckOpsManagerCompatibility(pathToTile, "2.10.3")).To(Succeed())
+		})
+
+		It("rejects newer versions", func() {
+			err := replicator.CheckOpsManagerCompatibility(pathToTile, "2.11")
+			Expect(err).To(MatchError("pas-windows supports Ops Manager 2.10 at most, target is 2.11"))
+
+			err = replicator.CheckOpsManagerCompatibility(pathToTile, "3.0.1")
+			Expect(err).To(MatchError("pas-windows supports Ops Manager 2.10 at most, target is 3.0.1"))
+		})
+
+		It("still rejects older versions", func() {
+			err := replicator.CheckOpsManagerCompatibility(pathToTile, "2.3")
+			Expect(err).To(MatchError("pas-windows requires Ops Manager 2.4 or later, target is 2.3"))
+		})
+	})
+
+	Context("when the tile does not declare a metadata_version", func() {
+		It("accepts any version", func() {
+			pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: "name: pas-windows\n"})
+
+			Expect(replicator.CheckOpsManagerCompatibility(pathToTile, "1.0")).To(Succeed())
+		})
+	})
+
+	Context("when the target version is invalid", func() {
+		It("returns an error", func() {
+			err := replicator.CheckOpsManagerCompatibility(pathToTile, "2.x")
+			Expect(err).To(MatchError("invalid Ops Manager version 2.x"))
+		})
+	})
+
+	Context("when the tile has no metadata", func() {
+		It("returns an error", func() {
+			pathToTile = writeTile(tileEntry{name: "releases/some-release.tgz"})
+
+			err := replicator.CheckOpsManagerCompatibility(pathToTile, "2.4")
+			Expect(err).To(MatchError(pathToTile + " does not contain tile metadata"))
+		})
+	})
+
+	Context("when the tile cannot be opened", func() {
+		It("returns an error", func() {
+			err := replicator.CheckOpsManagerCompatibility("some-bogus-path", "2.4")
+			Expect(err).To(MatchError("could not open source zip file"))
+		})
+	})
+})
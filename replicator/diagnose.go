@@ -0,0 +1,103 @@
+package replicator
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem Diagnose found with a tile. Errors stop Replicate
+// from replicating the tile; warnings do not.
+type Diagnostic struct {
+	Severity   Severity
+	Message    string
+	Suggestion string
+}
+
+// Diagnose inspects the tile at path the way Replicate would and explains
+// any problems it finds, with a suggested fix for each. An error is only
+// returned if the tile cannot be read at all.
+func (t TileReplicator) Diagnose(path string) ([]Diagnostic, error) {
+	srcTileZip, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	diagnostics := []Diagnostic{}
+	if uncompressedSize(srcTileZip.File) >= zip64SizeThreshold || len(srcTileZip.File) >= zip64CountThreshold {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity:   SeverityWarning,
+			Message:    fmt.Sprintf("%s requires zip64", path),
+			Suggestion: "make sure the target Ops Manager can read zip64 tiles",
+		})
+	}
+
+	member, contents, err := readMetadataFile(&srcTileZip.Reader, t.matcher(), ApplicationConfig{})
+	if err != nil {
+		return append(diagnostics, Diagnostic{
+			Severity:   SeverityError,
+			Message:    err.Error(),
+			Suggestion: "set MetadataPath to the metadata file of the product to replicate",
+		}), nil
+	}
+	if member == "" {
+		return append(diagnostics, Diagnostic{
+			Severity:   SeverityError,
+			Message:    fmt.Sprintf("%s does not contain tile metadata", path),
+			Suggestion: "check that the file is a tile, with its metadata under metadata/",
+		}), nil
+	}
+
+	metadata, err := parseProductMetadata(contents)
+	if err != nil {
+		return append(diagnostics, Diagnostic{
+			Severity:   SeverityError,
+			Message:    fmt.Sprintf("metadata %s does not parse: %s", member, err),
+			Suggestion: "fix the YAML in the tile's metadata",
+		}), nil
+	}
+
+	if _, ok := metadata["label"]; !ok {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity:   SeverityError,
+			Message:    "Tile metadata file is missing required tile property 'label'",
+			Suggestion: fmt.Sprintf("add a label to %s", member),
+		})
+	}
+
+	tileName, ok := metadata["name"]
+	if !ok {
+		return append(diagnostics, Diagnostic{
+			Severity:   SeverityError,
+			Message:    "Tile metadata file is missing required tile property 'name'",
+			Suggestion: fmt.Sprintf("add a name to %s", member),
+		}), nil
+	}
+
+	name := fmt.Sprintf("%v", tileName)
+	if t.handler(name) == nil && !contains(supportedTiles, name) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity:   SeverityError,
+			Message:    fmt.Sprintf("the replicator does not replicate %s", name),
+			Suggestion: fmt.Sprintf("use one of the supported tiles %s, or register a TileHandler for %s", supportedTiles, name),
+		})
+	}
+
+	if replacement, ok := deprecatedTiles[name]; ok {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity:   SeverityWarning,
+			Message:    fmt.Sprintf("%s is deprecated", name),
+			Suggestion: fmt.Sprintf("replicate %s instead", replacement),
+		})
+	}
+
+	return diagnostics, nil
+}
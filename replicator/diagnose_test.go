@@ -0,0 +1,110 @@
+package replicator_test
+
+import (
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("Diagnose", func() {
+	var tileReplicator replicator.TileReplicator
+
+	BeforeEach(func() {
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+	})
+
+	It("finds nothing wrong with a supported tile", func() {
+		diagnostics, err := tileReplicator.Diagnose(filepath.Join("..", "fixtures", "ist.pivotal"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diagnostics).To(BeEmpty())
+	})
+
+	It("warns about deprecated tiles", func() {
+		diagnostics, err := tileReplicator.Diagnose(filepath.Join("..", "fixtures", "wrt.pivotal"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diagnostics).To(Equal([]replicator.Diagnostic{{
+			Severity:   replicator.SeverityWarning,
+			Message:    "p-windows-runtime is deprecated",
+			Suggestion: "replicate pas-windows instead",
+		}}))
+	})
+
+	It("explains how to replicate an unsupported tile", func() {
+		pathToTile := writeTile(tileEntry{name: "metadata/some-tile.yml", contents: "name: some-tile\nlabel: Some Tile\n"})
+
+		diagnostics, err := tileReplicator.Diagnose(pathToTile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diagnostics).To(Equal([]replicator.Diagnostic{{
+			Severity:   replicator.SeverityError,
+			Message:    "the replicator does not replicate some-tile",
+			Suggestion: "use one of the supported tiles [p-isolation-segment p-windows-runtime pas-windows mongodb-on-demand], or register a TileHandler for some-tile",
+		}}))
+	})
+
+	It("reports missing required properties", func() {
+		pathToTile := writeTile(tileEntry{name: "metadata/some-tile.yml", contents: "description: no name or label\n"})
+
+		diagnostics, err := tileReplicator.Diagnose(pathToTile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diagnostics).To(Equal([]replicator.Diagnostic{
+			{
+				Severity:   replicator.SeverityError,
+				Message:    "Tile metadata file is missing required tile property 'label'",
+				Suggestion: "add a label to metadata/some-tile.yml",
+			},
+			{
+				Severity:   replicator.SeverityError,
+				Message:    "Tile metadata file is missing required tile property 'name'",
+				Suggestion: "add a name to metadata/some-tile.yml",
+			},
+		}))
+	})
+
+	It("reports metadata that does not parse", func() {
+		pathToTile := writeTile(tileEntry{name: "metadata/some-tile.yml", contents: "name: [some-tile\n"})
+
+		diagnostics, err := tileReplicator.Diagnose(pathToTile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diagnostics).To(HaveLen(1))
+		Expect(diagnostics[0].Severity).To(Equal(replicator.SeverityError))
+		Expect(diagnostics[0].Message).To(HavePrefix("metadata metadata/some-tile.yml does not parse: "))
+	})
+
+	It("reports tiles without metadata", func() {
+		pathToTile := writeTile(tileEntry{name: "releases/some-release.tgz"})
+
+		diagnostics, err := tileReplicator.Diagnose(pathToTile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diagnostics).To(Equal([]replicator.Diagnostic{{
+			Severity:   replicator.SeverityError,
+			Message:    pathToTile + " does not contain tile metadata",
+			Suggestion: "check that the file is a tile, with its metadata under metadata/",
+		}}))
+	})
+
+	It("reports tiles with several products", func() {
+		pathToTile := writeTile(
+			tileEntry{name: "metadata/one.yml", contents: "name: p-isolation-segment\nlabel: One\n"},
+			tileEntry{name: "metadata/two.yml", contents: "name: pas-windows\nlabel: Two\n"},
+		)
+
+		diagnostics, err := tileReplicator.Diagnose(pathToTile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diagnostics).To(Equal([]replicator.Diagnostic{{
+			Severity:   replicator.SeverityError,
+			Message:    "the replicator does not replicate tiles with multiple products, found metadata files [metadata/one.yml metadata/two.yml]",
+			Suggestion: "set MetadataPath to the metadata file of the product to replicate",
+		}}))
+	})
+
+	Context("when the tile cannot be opened", func() {
+		It("returns an error", func() {
+			_, err := tileReplicator.Diagnose("some-bogus-path")
+			Expect(err).To(MatchError("could not open source zip file"))
+		})
+	})
+})
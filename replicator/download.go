@@ -0,0 +1,181 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// downloadAttempts bounds how many times an interrupted download is resumed.
+const downloadAttempts = 3
+
+// defaultRetryDelay is how long ReplicateURL waits before resuming an
+// interrupted download the first time. The delay doubles with each attempt.
+const defaultRetryDelay = time.Second
+
+const downloadingLogFormat = "downloading %s\n"
+
+// WithHTTPClient sets the client ReplicateURL downloads with. The default is
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(t *TileReplicator) {
+		t.httpClient = client
+	}
+}
+
+// WithRetryDelay sets how long ReplicateURL waits before resuming an
+// interrupted download the first time. The default is one second.
+func WithRetryDelay(delay time.Duration) Option {
+	return func(t *TileReplicator) {
+		t.retryDelay = delay
+	}
+}
+
+// ReplicateURL downloads the tile at url to a temporary file and replicates
+// it as Replicate would; config.Path is ignored.
+func (t TileReplicator) ReplicateURL(url string, config ApplicationConfig) error {
+	return t.ReplicateURLContext(context.Background(), url, config)
+}
+
+// ReplicateURLContext is ReplicateURL with a context bounding the download.
+// Interrupted downloads are resumed with Range requests when the server
+// supports them.
+func (t TileReplicator) ReplicateURLContext(ctx context.Context, url string, config ApplicationConfig) error {
+	config.Path = url
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	t.logger.Printf(downloadingLogFormat, url)
+
+	tmpFile, err := ioutil.TempFile("", "replicator-download-")
+	if err != nil {
+		return err // not tested
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	err = t.download(ctx, url, tmpFile)
+	if err != nil {
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err // not tested
+	}
+
+	config.Path = tmpFile.Name()
+	config.source = tileSource{location: url}
+	return t.Replicate(config)
+}
+
+type downloadStatusError struct {
+	url    string
+	status string
+}
+
+func (e downloadStatusError) Error() string {
+	return fmt.Sprintf("could not download %s: %s", e.url, e.status)
+}
+
+func (t TileReplicator) download(ctx context.Context, url string, dst *os.File) error {
+	delay := t.retryDelay
+	if delay == 0 {
+		delay = defaultRetryDelay
+	}
+
+	var err error
+	for attempt := 0; attempt < downloadAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			delay *= 2
+		}
+
+		err = t.downloadFrom(ctx, url, dst)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		if _, ok := err.(downloadStatusError); ok {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// downloadFrom appends to dst whatever it does not already hold.
+func (t TileReplicator) downloadFrom(ctx context.Context, url string, dst *os.File) error {
+	offset, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err // not tested
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := t.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			// The server resumed elsewhere, so the next attempt starts over.
+			err = restartDownload(dst)
+			if err != nil {
+				return err // not tested
+			}
+			return fmt.Errorf("could not resume %s at byte %d, the server sent %q", url, offset, resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range, so start over.
+		err = restartDownload(dst)
+		if err != nil {
+			return err // not tested
+		}
+	default:
+		return downloadStatusError{url: url, status: resp.Status}
+	}
+
+	_, err = io.Copy(dst, resp.Body)
+	return err
+}
+
+func restartDownload(dst *os.File) error {
+	err := dst.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = dst.Seek(0, io.SeekStart)
+	return err
+}
+
+func (t TileReplicator) client() *http.Client {
+	if t.httpClient == nil {
+		return http.DefaultClient
+	}
+
+	return t.httpClient
+}
@@ -0,0 +1,237 @@
+package replicator_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+type countingTransport struct {
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+var _ = Describe("ReplicateURL", func() {
+	var (
+		tile             []byte
+		pathToOutputTile string
+		tileReplicator   replicator.TileReplicator
+		server           *httptest.Server
+		handler          http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		var err error
+		tile, err = ioutil.ReadFile(filepath.Join("..", "fixtures", "ist.pivotal"))
+		Expect(err).NotTo(HaveOccurred())
+
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{}, replicator.WithRetryDelay(time.Millisecond))
+
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "ist.pivotal", time.Time{}, bytes.NewReader(tile))
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("downloads and replicates the tile", func() {
+		err := tileReplicator.ReplicateURL(server.URL+"/ist.pivotal", replicator.ApplicationConfig{
+			Output: pathToOutputTile,
+			Name:   "Magenta Foo",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-magenta-foo"))
+	})
+
+	It("uses the configured http client", func() {
+		transport := &countingTransport{}
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{}, replicator.WithHTTPClient(&http.Client{Transport: transport}))
+
+		err := tileReplicator.ReplicateURL(server.URL+"/ist.pivotal", replicator.ApplicationConfig{
+			Output: pathToOutputTile,
+			Name:   "Magenta Foo",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(transport.requests).To(Equal(1))
+	})
+
+	It("validates the config before downloading", func() {
+		var requests int
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			requests++
+		}
+
+		err := tileReplicator.ReplicateURL(server.URL+"/ist.pivotal", replicator.ApplicationConfig{
+			Output: pathToOutputTile,
+		})
+		Expect(err).To(MatchError("name must not be empty"))
+		Expect(requests).To(BeZero())
+	})
+
+	Context("when the download is interrupted", func() {
+		It("resumes it with a range request", func() {
+			var ranges []string
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				if len(ranges) == 1 {
+					w.Header().Set("Content-Length", strconv.Itoa(len(tile)))
+					w.Write(tile[:len(tile)/2])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				http.ServeContent(w, r, "ist.pivotal", time.Time{}, bytes.NewReader(tile))
+			}
+
+			err := tileReplicator.ReplicateURL(server.URL+"/ist.pivotal", replicator.ApplicationConfig{
+				Output: pathToOutputTile,
+				Name:   "Magenta Foo",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ranges).To(Equal([]string{"", fmt.Sprintf("bytes=%d-", len(tile)/2)}))
+			Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-magenta-foo"))
+		})
+
+		It("waits before each attempt", func() {
+			var requested []time.Time
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				requested = append(requested, time.Now())
+				if len(requested) < 3 {
+					w.Header().Set("Content-Length", strconv.Itoa(len(tile)))
+					w.Write(tile[:len(tile)/2])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				http.ServeContent(w, r, "ist.pivotal", time.Time{}, bytes.NewReader(tile))
+			}
+
+			tileReplicator = replicator.NewTileReplicator(&fakes.Logger{}, replicator.WithRetryDelay(50*time.Millisecond))
+			err := tileReplicator.ReplicateURL(server.URL+"/ist.pivotal", replicator.ApplicationConfig{
+				Output: pathToOutputTile,
+				Name:   "Magenta Foo",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(requested).To(HaveLen(3))
+			Expect(requested[1].Sub(requested[0])).To(BeNumerically(">=", 50*time.Millisecond))
+			Expect(requested[2].Sub(requested[1])).To(BeNumerically(">=", 100*time.Millisecond))
+		})
+
+		It("starts over when the server ignores the range", func() {
+			var ranges []string
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				w.Header().Set("Content-Length", strconv.Itoa(len(tile)))
+				if len(ranges) == 1 {
+					w.Write(tile[:len(tile)/2])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				w.Write(tile)
+			}
+
+			err := tileReplicator.ReplicateURL(server.URL+"/ist.pivotal", replicator.ApplicationConfig{
+				Output: pathToOutputTile,
+				Name:   "Magenta Foo",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ranges).To(Equal([]string{"", fmt.Sprintf("bytes=%d-", len(tile)/2)}))
+			Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-magenta-foo"))
+		})
+
+		It("starts over when the server resumes at another offset", func() {
+			var ranges []string
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				switch len(ranges) {
+				case 1:
+					w.Header().Set("Content-Length", strconv.Itoa(len(tile)))
+					w.Write(tile[:len(tile)/2])
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				case 2:
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(tile)-1, len(tile)))
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(tile)
+				default:
+					http.ServeContent(w, r, "ist.pivotal", time.Time{}, bytes.NewReader(tile))
+				}
+			}
+
+			err := tileReplicator.ReplicateURL(server.URL+"/ist.pivotal", replicator.ApplicationConfig{
+				Output: pathToOutputTile,
+				Name:   "Magenta Foo",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ranges).To(Equal([]string{"", fmt.Sprintf("bytes=%d-", len(tile)/2), ""}))
+			Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-magenta-foo"))
+		})
+	})
+
+	Context("when the server responds with an error", func() {
+		It("returns an error without retrying", func() {
+			var requests int
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				http.NotFound(w, r)
+			}
+
+			err := tileReplicator.ReplicateURL(server.URL+"/missing.pivotal", replicator.ApplicationConfig{
+				Output: pathToOutputTile,
+				Name:   "Magenta Foo",
+			})
+			Expect(err).To(MatchError(fmt.Sprintf("could not download %s/missing.pivotal: 404 Not Found", server.URL)))
+			Expect(requests).To(Equal(1))
+			Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when the context times out", func() {
+		It("returns the context's error", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			err := tileReplicator.ReplicateURLContext(ctx, server.URL+"/ist.pivotal", replicator.ApplicationConfig{
+				Output: pathToOutputTile,
+				Name:   "Magenta Foo",
+			})
+			Expect(err).To(Equal(context.DeadlineExceeded))
+		})
+	})
+})
@@ -0,0 +1,97 @@
+package replicator
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func EstimateOutputSize(path string) (int64, error) {
+	srcTileZip, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	return uncompressedSize(srcTileZip.File), nil
+}
+
+// ProgressTotal returns the total Replicate reports to config.Progress: the
+// uncompressed size of the members it copies, without those SlimMode and
+// FileFilter leave out.
+func (t TileReplicator) ProgressTotal(config ApplicationConfig) (int64, error) {
+	srcTileZip, err := zip.OpenReader(config.Path)
+	if err != nil {
+		return 0, errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	member, _, err := readMetadataFile(&srcTileZip.Reader, t.matcher(), config)
+	if err != nil {
+		return 0, err
+	}
+
+	files, _ := t.copiedFiles(&srcTileZip.Reader, member, config)
+
+	return uncompressedSize(files), nil
+}
+
+func uncompressedSize(files []*zip.File) int64 {
+	var size int64
+	for _, file := range files {
+		size += int64(file.UncompressedSize64)
+	}
+
+	return size
+}
+
+// ReplacementCounts returns how often each token that Replicate would
+// rename, the tile name and its job types, appears in the tile's metadata.
+// A count of zero for a token the tile is expected to contain suggests the
+// duplicate will not be renamed as intended.
+func (t TileReplicator) ReplacementCounts(path string, config ApplicationConfig) (map[string]int, error) {
+	srcTileZip, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	member, contents, err := readMetadataFile(&srcTileZip.Reader, t.matcher(), config)
+	if err != nil {
+		return nil, err
+	}
+	if member == "" {
+		return nil, fmt.Errorf("%s does not contain tile metadata", path)
+	}
+
+	metadata, err := parseProductMetadata(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	tileName, ok := metadata["name"]
+	if !ok {
+		return nil, errors.New("Tile metadata file is missing required tile property 'name'")
+	}
+
+	return t.replacementCounts(contents, fmt.Sprintf("%v", tileName), config), nil
+}
+
+func (t TileReplicator) replacementCounts(contents []byte, tileName string, config ApplicationConfig) map[string]int {
+	documents, index, err := productDocument(contents)
+	if err == nil {
+		contents = []byte(documents[index])
+	}
+
+	counts := map[string]int{tileName: strings.Count(string(contents), tileName)}
+	if t.handler(tileName) != nil {
+		return counts
+	}
+
+	for jobType := range t.jobRenames(tileName, config) {
+		counts[jobType] = strings.Count(string(contents), jobType)
+	}
+
+	return counts
+}
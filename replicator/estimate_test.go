@@ -0,0 +1,69 @@
+package replicator_test
+
+import (
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("EstimateOutputSize", func() {
+	It("sums the uncompressed sizes of the tile's members", func() {
+		size, err := replicator.EstimateOutputSize(filepath.Join("..", "fixtures", "ist.pivotal"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(size).To(Equal(int64(1960)))
+	})
+
+	Context("when the tile cannot be opened", func() {
+		It("returns an error", func() {
+			_, err := replicator.EstimateOutputSize("some-bogus-path")
+			Expect(err).To(MatchError("could not open source zip file"))
+		})
+	})
+})
+
+var _ = Describe("ReplacementCounts", func() {
+	var tileReplicator replicator.TileReplicator
+
+	BeforeEach(func() {
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+	})
+
+	It("counts the tokens that will be renamed in the metadata", func() {
+		counts, err := tileReplicator.ReplacementCounts(filepath.Join("..", "fixtures", "ist.pivotal"), replicator.ApplicationConfig{Name: "blue"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(counts).To(Equal(map[string]int{
+			"p-isolation-segment": 1,
+			"isolated_diego_cell": 11,
+			"isolated_ha_proxy":   4,
+			"isolated_router":     2,
+		}))
+	})
+
+	It("only counts the job types that will be renamed", func() {
+		counts, err := tileReplicator.ReplacementCounts(filepath.Join("..", "fixtures", "ist.pivotal"), replicator.ApplicationConfig{
+			Name:           "blue",
+			RenameJobTypes: []string{"isolated_router"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(counts).To(Equal(map[string]int{
+			"p-isolation-segment": 1,
+			"isolated_router":     2,
+		}))
+	})
+
+	Context("when the tile has no metadata", func() {
+		It("returns an error", func() {
+			pathToTile := writeTile(tileEntry{name: "releases/some-release.tgz"})
+
+			_, err := tileReplicator.ReplacementCounts(pathToTile, replicator.ApplicationConfig{Name: "blue"})
+			Expect(err).To(MatchError(pathToTile + " does not contain tile metadata"))
+		})
+	})
+})
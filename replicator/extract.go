@@ -0,0 +1,206 @@
+package replicator
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func ExtractFile(path, memberName string, w io.Writer) error {
+	srcTileZip, err := zip.OpenReader(path)
+	if err != nil {
+		return errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	for _, srcFile := range srcTileZip.File {
+		if srcFile.Name != memberName {
+			continue
+		}
+
+		srcFileReader, err := srcFile.Open()
+		if err != nil {
+			return err // not tested
+		}
+		defer srcFileReader.Close()
+
+		_, err = io.Copy(w, srcFileReader)
+		return err
+	}
+
+	return fmt.Errorf("%s does not contain %s", path, memberName)
+}
+
+// ExtractTo writes the duplicate's members beneath destDir instead of into
+// a zip. The members are the ones Replicate would write, transformed the
+// same way: SlimMode, FileFilter, member Replacements and
+// IncludeExternalArtifacts all apply. Options that describe the zip
+// itself, such as WriteManifest, do not.
+func (t TileReplicator) ExtractTo(path, destDir string, config ApplicationConfig) error {
+	config.Path = path
+	if err := config.validate(false); err != nil {
+		return err
+	}
+
+	config, err := withResolvedName(config)
+	if err != nil {
+		return err
+	}
+
+	srcTileZip, err := zip.OpenReader(path)
+	if err != nil {
+		return errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	metadata, err := t.readMetadata(&srcTileZip.Reader, config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := replicationContext(config)
+	defer cancel()
+
+	files, rejected := t.copiedFiles(&srcTileZip.Reader, metadata.member, config)
+	if !config.Quiet {
+		for _, name := range rejected {
+			t.logger.Printf(skippedLogFormat, name)
+		}
+	}
+
+	for _, srcFile := range files {
+		err = checkTimeout(ctx, config)
+		if err != nil {
+			return err
+		}
+
+		err = t.checkName(srcFile.Name, config)
+		if err != nil {
+			return err
+		}
+
+		target, err := extractTarget(destDir, t.destinationName(srcFile.Name, config))
+		if err != nil {
+			return fmt.Errorf("%s would be extracted outside of %s", srcFile.Name, destDir)
+		}
+
+		mode := t.destinationMode(srcFile, config)
+		if mode.IsDir() {
+			err = os.MkdirAll(target, mode.Perm())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = t.extractMember(ctx, target, mode.Perm(), srcFile, metadata, config)
+		if timeoutErr := checkTimeout(ctx, config); timeoutErr != nil {
+			return timeoutErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.extractExternalArtifacts(destDir, config)
+}
+
+// extractTarget returns where name is extracted beneath destDir, or an
+// error if that is outside of it.
+func extractTarget(destDir, name string) (string, error) {
+	target := filepath.Join(destDir, filepath.FromSlash(name))
+	if target != filepath.Clean(destDir) && !strings.HasPrefix(target, filepath.Clean(destDir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s would be extracted outside of %s", name, destDir)
+	}
+
+	return target, nil
+}
+
+func (t TileReplicator) extractMember(ctx context.Context, target string, perm os.FileMode, srcFile *zip.File, metadata productMetadata, config ApplicationConfig) error {
+	dstFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	err = writeContents(contextWriter{ctx, dstFile}, srcFile, metadata, config)
+	if err != nil {
+		return err
+	}
+
+	return dstFile.Close()
+}
+
+// extractExternalArtifacts copies the files in IncludeExternalArtifacts
+// beneath destDir, as writeExternalArtifacts adds them to a tile.
+func (t TileReplicator) extractExternalArtifacts(destDir string, config ApplicationConfig) error {
+	var names []string
+	for name := range config.IncludeExternalArtifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		target, err := extractTarget(destDir, t.destinationName(name, config))
+		if err != nil {
+			return err
+		}
+
+		err = extractExternalArtifact(target, config.IncludeExternalArtifacts[name], config)
+		if err != nil {
+			return fmt.Errorf("could not include %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+func extractExternalArtifact(target, path string, config ApplicationConfig) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err // not tested
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+
+	perm := fi.Mode().Perm()
+	if config.NormalizeModes {
+		perm = normalizedFileMode
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	dstFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	_, err = copyBuffer(dstFile, src, config.CopyBufferSize)
+	if err != nil {
+		return err // not tested
+	}
+
+	return dstFile.Close()
+}
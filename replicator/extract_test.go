@@ -0,0 +1,141 @@
+package replicator_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+	"github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("ExtractFile", func() {
+	var pathToTile string
+
+	BeforeEach(func() {
+		pathToTile = filepath.Join("..", "fixtures", "ist.pivotal")
+	})
+
+	It("writes the member's contents", func() {
+		buffer := &bytes.Buffer{}
+		err := replicator.ExtractFile(pathToTile, "metadata/p-isolation-segment.yml", buffer)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(buffer.String()).To(HavePrefix("name: p-isolation-segment\nlabel: PCF Isolation Segment\n"))
+	})
+
+	Context("when the member does not exist", func() {
+		It("returns an error", func() {
+			err := replicator.ExtractFile(pathToTile, "metadata/missing.yml", ioutil.Discard)
+			Expect(err).To(MatchError(pathToTile + " does not contain metadata/missing.yml"))
+		})
+	})
+
+	Context("when the tile cannot be opened", func() {
+		It("returns an error", func() {
+			err := replicator.ExtractFile("some-bogus-path", "metadata/missing.yml", ioutil.Discard)
+			Expect(err).To(MatchError("could not open source zip file"))
+		})
+	})
+})
+
+var _ = Describe("ExtractTo", func() {
+	var (
+		pathToTile     string
+		destDir        string
+		tileReplicator replicator.TileReplicator
+	)
+
+	BeforeEach(func() {
+		pathToTile = filepath.Join("..", "fixtures", "ist.pivotal")
+
+		var err error
+		destDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+	})
+
+	It("writes the transformed members to the directory", func() {
+		err := tileReplicator.ExtractTo(pathToTile, destDir, replicator.ApplicationConfig{Name: "Magenta Foo"})
+		Expect(err).NotTo(HaveOccurred())
+
+		metadata, err := ioutil.ReadFile(filepath.Join(destDir, "metadata", "p-isolation-segment.yml"))
+		Expect(err).NotTo(HaveOccurred())
+
+		expectedMetadata, err := ioutil.ReadFile(filepath.Join("..", "fixtures", "expected-ist-metadata.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metadata).To(gomegamatchers.MatchYAML(expectedMetadata))
+
+		for _, name := range tileFileNames(pathToTile) {
+			if strings.HasSuffix(name, "/") || strings.HasPrefix(name, "metadata/") {
+				continue
+			}
+			contents, err := ioutil.ReadFile(filepath.Join(destDir, filepath.FromSlash(name)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal(readTileFile(pathToTile, name)))
+		}
+	})
+
+	It("writes the members Replicate would write", func() {
+		pathToTile = writeTile(
+			tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+			tileEntry{name: "releases/some-release.tgz", contents: "release bits"},
+			tileEntry{name: "migrations/v1/201701251230_migration.js", contents: "p-isolation-segment migration"},
+			tileEntry{name: "docs/notes.txt", contents: "notes"},
+		)
+		artifactDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToArtifact := filepath.Join(artifactDir, "README.md")
+		Expect(ioutil.WriteFile(pathToArtifact, []byte("readme"), 0644)).To(Succeed())
+
+		err = tileReplicator.ExtractTo(pathToTile, destDir, replicator.ApplicationConfig{
+			Name:     "Magenta Foo",
+			SlimMode: true,
+			FileFilter: func(name string, size int64) bool {
+				return !strings.HasPrefix(name, "docs/")
+			},
+			Replacements: []replicator.ReplacementRule{
+				{Old: "p-isolation-segment", New: "p-isolation-segment-magenta-foo", FileGlob: "migrations/*/*.js"},
+			},
+			IncludeExternalArtifacts: map[string]string{"docs/README.md": pathToArtifact},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(filepath.Join(destDir, "releases", "some-release.tgz")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(destDir, "docs", "notes.txt")).NotTo(BeAnExistingFile())
+
+		migration, err := ioutil.ReadFile(filepath.Join(destDir, "migrations", "v1", "201701251230_migration.js"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(migration)).To(Equal("p-isolation-segment-magenta-foo migration"))
+
+		readme, err := ioutil.ReadFile(filepath.Join(destDir, "docs", "README.md"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(readme)).To(Equal("readme"))
+	})
+
+	Context("when a member would escape the directory", func() {
+		It("returns an error", func() {
+			pathToTile = writeTile(tileEntry{name: "../escaped.txt", contents: "oops"})
+
+			err := tileReplicator.ExtractTo(pathToTile, destDir, replicator.ApplicationConfig{Name: "Magenta Foo"})
+			Expect(err).To(MatchError(fmt.Sprintf("../escaped.txt would be extracted outside of %s", destDir)))
+			Expect(filepath.Join(filepath.Dir(destDir), "escaped.txt")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when the metadata cannot be transformed", func() {
+		It("returns an error", func() {
+			pathToTile = writeTile(tileEntry{name: "metadata/some-tile.yml", contents: "name: some-tile\n"})
+
+			err := tileReplicator.ExtractTo(pathToTile, destDir, replicator.ApplicationConfig{Name: "Magenta Foo"})
+			Expect(err).To(MatchError(ContainSubstring("the replicator does not replicate some-tile")))
+		})
+	})
+})
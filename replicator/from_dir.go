@@ -0,0 +1,100 @@
+package replicator
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ReplicateFromDir replicates the unpacked tile in dir, as written by
+// ExtractTo, into a zipped tile at config.Output. config.Path is ignored.
+func (t TileReplicator) ReplicateFromDir(dir string, config ApplicationConfig) error {
+	config.Path = dir
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile("", "replicator-dir-")
+	if err != nil {
+		return err // not tested
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	err = packDir(dir, tmpFile)
+	if err != nil {
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err // not tested
+	}
+
+	config.Path = tmpFile.Name()
+	config.source = tileSource{location: dir, packed: true}
+	return t.Replicate(config)
+}
+
+// packDir stores the tree beneath dir in an uncompressed zip, since
+// Replicate compresses every member again anyway. Links to files are stored
+// as the files they link to.
+func packDir(dir string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err // not tested
+		}
+		if name == "." {
+			return nil
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return fmt.Errorf("cannot pack %s, it links to a directory", name)
+			}
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err // not tested
+		}
+		header.Name = filepath.ToSlash(name)
+		header.Method = zip.Store
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		dst, err := zw.CreateHeader(header)
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		_, err = io.Copy(dst, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
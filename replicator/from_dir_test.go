@@ -0,0 +1,108 @@
+package replicator_test
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("ReplicateFromDir", func() {
+	var (
+		dir              string
+		pathToOutputTile string
+		tileReplicator   replicator.TileReplicator
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.MkdirAll(filepath.Join(dir, "metadata"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(dir, "migrations", "v1"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(dir, "releases"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "metadata", "p-isolation-segment.yml"), []byte("name: p-isolation-segment\nlabel: PCF Isolation Segment\n"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "releases", "some-release.tgz"), []byte("release"), 0600)).To(Succeed())
+
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+	})
+
+	It("validates the config before packing the directory", func() {
+		err := tileReplicator.ReplicateFromDir(filepath.Join(dir, "missing"), replicator.ApplicationConfig{
+			Output: pathToOutputTile,
+		})
+		Expect(err).To(MatchError("name must not be empty"))
+	})
+
+	It("stores links to files as the files they link to", func() {
+		Expect(os.Symlink("some-release.tgz", filepath.Join(dir, "releases", "link.tgz"))).To(Succeed())
+
+		err := tileReplicator.ReplicateFromDir(dir, replicator.ApplicationConfig{
+			Output: pathToOutputTile,
+			Name:   "Magenta Foo",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		zr, err := zip.OpenReader(pathToOutputTile)
+		Expect(err).NotTo(HaveOccurred())
+		defer zr.Close()
+
+		for _, file := range zr.File {
+			if file.Name == "releases/link.tgz" {
+				Expect(file.Mode().IsRegular()).To(BeTrue())
+			}
+		}
+		Expect(readTileFile(pathToOutputTile, "releases/link.tgz")).To(Equal("release"))
+	})
+
+	It("refuses links to directories", func() {
+		Expect(os.Symlink("v1", filepath.Join(dir, "migrations", "v2"))).To(Succeed())
+
+		err := tileReplicator.ReplicateFromDir(dir, replicator.ApplicationConfig{
+			Output: pathToOutputTile,
+			Name:   "Magenta Foo",
+		})
+		Expect(err).To(MatchError("cannot pack migrations/v2, it links to a directory"))
+	})
+
+	It("zips the directory tree with transformed metadata", func() {
+		err := tileReplicator.ReplicateFromDir(dir, replicator.ApplicationConfig{
+			Output: pathToOutputTile,
+			Name:   "Magenta Foo",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{
+			"metadata/",
+			"metadata/p-isolation-segment.yml",
+			"migrations/",
+			"migrations/v1/",
+			"releases/",
+			"releases/some-release.tgz",
+		}))
+		Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-magenta-foo"))
+		Expect(readTileFile(pathToOutputTile, "releases/some-release.tgz")).To(Equal("release"))
+	})
+
+	Context("when the directory does not exist", func() {
+		It("returns an error", func() {
+			err := tileReplicator.ReplicateFromDir(filepath.Join(dir, "missing"), replicator.ApplicationConfig{
+				Output: pathToOutputTile,
+				Name:   "Magenta Foo",
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,125 @@
+package replicator
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ReplicateIfChanged replicates config only if previousOutput is not
+// already what Replicate would produce: its metadata must equal the newly
+// transformed metadata and every other member Replicate would write, external
+// artifacts included, must match the CRC and size of its source. It reports whether a new tile was written. When nothing
+// changed, nothing is written and previousOutput remains the duplicate.
+func (t TileReplicator) ReplicateIfChanged(config ApplicationConfig, previousOutput string) (bool, error) {
+	if err := config.Validate(); err != nil {
+		return false, err
+	}
+
+	config, err := withResolvedName(config)
+	if err != nil {
+		return false, err
+	}
+
+	srcTileZip, err := zip.OpenReader(config.Path)
+	if err != nil {
+		return false, errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	metadata, err := t.readMetadata(&srcTileZip.Reader, config)
+	if err != nil {
+		return false, err
+	}
+
+	if !t.unchanged(&srcTileZip.Reader, previousOutput, metadata, config) {
+		return true, t.Replicate(config)
+	}
+
+	return false, nil
+}
+
+func (t TileReplicator) unchanged(srcTileZip *zip.Reader, previousOutput string, metadata productMetadata, config ApplicationConfig) bool {
+	previousTileZip, err := zip.OpenReader(previousOutput)
+	if err != nil {
+		return false
+	}
+	defer previousTileZip.Close()
+
+	files, _ := t.copiedFiles(srcTileZip, metadata.member, config)
+	if len(previousTileZip.File) != len(files)+len(config.IncludeExternalArtifacts) {
+		return false
+	}
+
+	previousFiles := map[string]*zip.File{}
+	for _, previousFile := range previousTileZip.File {
+		previousFiles[previousFile.Name] = previousFile
+	}
+
+	for name, path := range config.IncludeExternalArtifacts {
+		previousFile, ok := previousFiles[t.destinationName(name, config)]
+		if !ok || !fileMatches(previousFile, path) {
+			return false
+		}
+	}
+
+	for _, srcFile := range files {
+		previousFile, ok := previousFiles[t.memberName(srcFile, config)]
+		if !ok {
+			return false
+		}
+
+		if srcFile.Name == metadata.member {
+			if !memberEquals(previousFile, metadata.contents) {
+				return false
+			}
+			continue
+		}
+
+		if previousFile.CRC32 != srcFile.CRC32 || previousFile.UncompressedSize64 != srcFile.UncompressedSize64 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fileMatches reports whether file has the CRC and size of the file at path.
+func fileMatches(file *zip.File, path string) bool {
+	src, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer src.Close()
+
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(crc, src)
+	if err != nil {
+		return false // not tested
+	}
+
+	return file.CRC32 == crc.Sum32() && file.UncompressedSize64 == uint64(n)
+}
+
+func memberEquals(file *zip.File, contents []byte) bool {
+	if file.UncompressedSize64 != uint64(len(contents)) {
+		return false
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return false // not tested
+	}
+	defer reader.Close()
+
+	previousContents, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return false // not tested
+	}
+
+	return bytes.Equal(previousContents, contents)
+}
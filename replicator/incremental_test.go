@@ -0,0 +1,161 @@
+package replicator_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("ReplicateIfChanged", func() {
+	var (
+		pathToPreviousTile string
+		pathToOutputTile   string
+		tileReplicator     replicator.TileReplicator
+		config             replicator.ApplicationConfig
+	)
+
+	BeforeEach(func() {
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToPreviousTile = filepath.Join(tempDir, "previous-tile.pivotal")
+		pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+		config = replicator.ApplicationConfig{
+			Path:   filepath.Join("..", "fixtures", "ist.pivotal"),
+			Output: pathToPreviousTile,
+			Name:   "Magenta Foo",
+		}
+
+		Expect(tileReplicator.Replicate(config)).To(Succeed())
+		config.Output = pathToOutputTile
+	})
+
+	It("skips replication when nothing changed", func() {
+		replicated, err := tileReplicator.ReplicateIfChanged(config, pathToPreviousTile)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(replicated).To(BeFalse())
+		Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+	})
+
+	It("replicates when the transformed metadata differs", func() {
+		config.Name = "Cyan Bar"
+
+		replicated, err := tileReplicator.ReplicateIfChanged(config, pathToPreviousTile)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(replicated).To(BeTrue())
+		Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-cyan-bar"))
+	})
+
+	It("replicates when another member differs", func() {
+		config.Path = writeTile(
+			tileEntry{name: "metadata/p-isolation-segment.yml", contents: readTileFile(filepath.Join("..", "fixtures", "ist.pivotal"), "metadata/p-isolation-segment.yml")},
+			tileEntry{name: "releases/some-release.tgz", contents: "a new release"},
+		)
+
+		replicated, err := tileReplicator.ReplicateIfChanged(config, pathToPreviousTile)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(replicated).To(BeTrue())
+		Expect(pathToOutputTile).To(BeAnExistingFile())
+	})
+
+	Context("when some members are left out", func() {
+		replicateTwice := func(configure func(*replicator.ApplicationConfig)) bool {
+			configure(&config)
+			config.Output = pathToPreviousTile
+			Expect(tileReplicator.Replicate(config)).To(Succeed())
+			config.Output = pathToOutputTile
+
+			replicated, err := tileReplicator.ReplicateIfChanged(config, pathToPreviousTile)
+			Expect(err).NotTo(HaveOccurred())
+			return replicated
+		}
+
+		It("skips replication of a slim tile that did not change", func() {
+			Expect(replicateTwice(func(config *replicator.ApplicationConfig) {
+				config.SlimMode = true
+			})).To(BeFalse())
+			Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+		})
+
+		It("skips replication of a filtered tile that did not change", func() {
+			Expect(replicateTwice(func(config *replicator.ApplicationConfig) {
+				config.FileFilter = func(name string, size int64) bool {
+					return !strings.HasPrefix(name, "migrations/")
+				}
+			})).To(BeFalse())
+			Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+		})
+
+		It("replicates when the previous tile has members the config leaves out", func() {
+			config.SlimMode = true
+
+			replicated, err := tileReplicator.ReplicateIfChanged(config, pathToPreviousTile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(replicated).To(BeTrue())
+		})
+	})
+
+	Context("when external artifacts are included", func() {
+		var pathToArtifact string
+
+		BeforeEach(func() {
+			artifact, err := ioutil.TempFile("", "")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = artifact.Write([]byte("some external artifact"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(artifact.Close()).To(Succeed())
+			pathToArtifact = artifact.Name()
+
+			config.IncludeExternalArtifacts = map[string]string{"docs/README.md": pathToArtifact}
+			config.PathPrefix = "tile"
+			config.Output = pathToPreviousTile
+			Expect(tileReplicator.Replicate(config)).To(Succeed())
+			config.Output = pathToOutputTile
+		})
+
+		It("skips replication when nothing changed", func() {
+			replicated, err := tileReplicator.ReplicateIfChanged(config, pathToPreviousTile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(replicated).To(BeFalse())
+		})
+
+		It("replicates when an artifact changed", func() {
+			Expect(ioutil.WriteFile(pathToArtifact, []byte("a new external artifact"), 0644)).To(Succeed())
+
+			replicated, err := tileReplicator.ReplicateIfChanged(config, pathToPreviousTile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(replicated).To(BeTrue())
+		})
+	})
+
+	It("replicates when there is no previous tile", func() {
+		Expect(os.Remove(pathToPreviousTile)).To(Succeed())
+
+		replicated, err := tileReplicator.ReplicateIfChanged(config, pathToPreviousTile)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(replicated).To(BeTrue())
+		Expect(pathToOutputTile).To(BeAnExistingFile())
+	})
+
+	Context("when the source cannot be transformed", func() {
+		It("returns an error", func() {
+			config.Path = filepath.Join("..", "fixtures", "ist-duplicated.pivotal")
+
+			replicated, err := tileReplicator.ReplicateIfChanged(config, pathToPreviousTile)
+			Expect(err).To(MatchError(ContainSubstring("the replicator does not replicate")))
+			Expect(replicated).To(BeFalse())
+		})
+	})
+})
@@ -1,7 +1,11 @@
 package replicator_test
 
 import (
+	"archive/zip"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -21,3 +25,65 @@ func TestReplicator(t *testing.T) {
 func formatLogLine(s string, v []interface{}) string {
 	return fmt.Sprintf(s, v...)
 }
+
+type tileEntry struct {
+	name     string
+	contents string
+}
+
+func writeTile(entries ...tileEntry) string {
+	tempDir, err := ioutil.TempDir("", "")
+	Expect(err).NotTo(HaveOccurred())
+
+	pathToTile := filepath.Join(tempDir, "tile.pivotal")
+	f, err := os.Create(pathToTile)
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = w.Write([]byte(entry.contents))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(zw.Close()).To(Succeed())
+
+	return pathToTile
+}
+
+func readTileFile(pathToTile, name string) string {
+	zr, err := zip.OpenReader(pathToTile)
+	Expect(err).NotTo(HaveOccurred())
+	defer zr.Close()
+
+	for _, file := range zr.File {
+		if file.Name == name {
+			f, err := file.Open()
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+
+			contents, err := ioutil.ReadAll(f)
+			Expect(err).NotTo(HaveOccurred())
+
+			return string(contents)
+		}
+	}
+
+	Fail(fmt.Sprintf("%s does not contain %s", pathToTile, name))
+	return ""
+}
+
+func tileFileNames(pathToTile string) []string {
+	zr, err := zip.OpenReader(pathToTile)
+	Expect(err).NotTo(HaveOccurred())
+	defer zr.Close()
+
+	var names []string
+	for _, file := range zr.File {
+		names = append(names, file.Name)
+	}
+
+	return names
+}
@@ -0,0 +1,56 @@
+package replicator
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+)
+
+// PlannedJobRenames returns the job renames, original name to new name,
+// that Replicate would apply to the tile at path. Nothing is written.
+// Tiles handled by a registered TileHandler have no planned renames.
+func (t TileReplicator) PlannedJobRenames(path string, config ApplicationConfig) (map[string]string, error) {
+	config.Path = path
+	if err := config.validate(false); err != nil {
+		return nil, err
+	}
+
+	config, err := withResolvedName(config)
+	if err != nil {
+		return nil, err
+	}
+
+	srcTileZip, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	member, contents, err := readMetadataFile(&srcTileZip.Reader, t.matcher(), config)
+	if err != nil {
+		return nil, err
+	}
+	if member == "" {
+		return nil, fmt.Errorf("%s does not contain tile metadata", path)
+	}
+
+	metadata, err := parseProductMetadata(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	tileName, ok := metadata["name"]
+	if !ok {
+		return nil, errors.New("Tile metadata file is missing required tile property 'name'")
+	}
+
+	if t.handler(fmt.Sprintf("%v", tileName)) != nil {
+		return map[string]string{}, nil
+	}
+	if !contains(supportedTiles, fmt.Sprintf("%v", tileName)) {
+		return nil, fmt.Errorf("the replicator does not replicate %s, supported tiles are %s", tileName, supportedTiles)
+	}
+
+	config.Name = t.expandName(fmt.Sprintf("%v", tileName), config.Name)
+	return t.jobRenames(fmt.Sprintf("%v", tileName), config), nil
+}
@@ -0,0 +1,110 @@
+package replicator_test
+
+import (
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("PlannedJobRenames", func() {
+	var tileReplicator replicator.TileReplicator
+
+	BeforeEach(func() {
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+	})
+
+	It("returns the isolation segment job renames", func() {
+		renames, err := tileReplicator.PlannedJobRenames(filepath.Join("..", "fixtures", "ist.pivotal"), replicator.ApplicationConfig{Name: "Magenta Foo"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(renames).To(Equal(map[string]string{
+			"isolated_diego_cell": "isolated_diego_cell_magenta_foo",
+			"isolated_ha_proxy":   "isolated_ha_proxy_magenta_foo",
+			"isolated_router":     "isolated_router_magenta_foo",
+		}))
+	})
+
+	It("honours the selected isolation segment job types", func() {
+		renames, err := tileReplicator.PlannedJobRenames(filepath.Join("..", "fixtures", "ist.pivotal"), replicator.ApplicationConfig{
+			Name:           "Magenta Foo",
+			RenameJobTypes: []string{"isolated_router"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(renames).To(Equal(map[string]string{"isolated_router": "isolated_router_magenta_foo"}))
+	})
+
+	It("resolves the name as Replicate does", func() {
+		renames, err := tileReplicator.PlannedJobRenames(filepath.Join("..", "fixtures", "ist.pivotal"), replicator.ApplicationConfig{
+			Name:             "Magenta Foo",
+			Foundation:       "prod",
+			FoundationInName: true,
+			RenameJobTypes:   []string{"isolated_router"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(renames).To(Equal(map[string]string{"isolated_router": "isolated_router_magenta_foo_prod"}))
+
+		renames, err = tileReplicator.PlannedJobRenames(filepath.Join("..", "fixtures", "ist.pivotal"), replicator.ApplicationConfig{
+			AutoName:       true,
+			RenameJobTypes: []string{"isolated_router"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(renames).To(HaveLen(1))
+		Expect(renames["isolated_router"]).To(MatchRegexp(`^isolated_router_\w+$`))
+	})
+
+	It("returns the windows job renames", func() {
+		for _, fixture := range []string{"wrt.pivotal", "wrt-2016.pivotal"} {
+			renames, err := tileReplicator.PlannedJobRenames(filepath.Join("..", "fixtures", fixture), replicator.ApplicationConfig{Name: "Azure Sea"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(renames).To(Equal(map[string]string{"windows_diego_cell": "windows_diego_cell_azure_sea"}))
+		}
+	})
+
+	Context("when planning the mongodb on-demand tile", func() {
+		var pathToTile string
+
+		BeforeEach(func() {
+			pathToTile = writeTile(tileEntry{name: "metadata/mongodb-on-demand.yml", contents: "name: mongodb-on-demand\nlabel: MongoDB Enterprise Service\n"})
+		})
+
+		It("returns the broker and dns aliases renames", func() {
+			renames, err := tileReplicator.PlannedJobRenames(pathToTile, replicator.ApplicationConfig{Name: "Magenta Foo"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(renames).To(Equal(map[string]string{
+				"mongodb_broker":      "mongodb_broker_magenta_foo",
+				"mongodb-dns-aliases": "mongodb-magenta_foo-dns-aliases",
+			}))
+		})
+
+		It("keeps the dns aliases when runtime configs are kept", func() {
+			renames, err := tileReplicator.PlannedJobRenames(pathToTile, replicator.ApplicationConfig{
+				Name:               "Magenta Foo",
+				KeepRuntimeConfigs: true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(renames).To(Equal(map[string]string{"mongodb_broker": "mongodb_broker_magenta_foo"}))
+		})
+	})
+
+	Context("when the tile is not supported", func() {
+		It("returns an error", func() {
+			_, err := tileReplicator.PlannedJobRenames(filepath.Join("..", "fixtures", "ist-duplicated.pivotal"), replicator.ApplicationConfig{Name: "Magenta Foo"})
+			Expect(err).To(MatchError(ContainSubstring("the replicator does not replicate p-isolation-segment-already-duplicated")))
+		})
+	})
+
+	Context("when the name is empty", func() {
+		It("returns an error", func() {
+			_, err := tileReplicator.PlannedJobRenames(filepath.Join("..", "fixtures", "ist.pivotal"), replicator.ApplicationConfig{})
+			Expect(err).To(MatchError("name must not be empty"))
+		})
+	})
+})
@@ -0,0 +1,66 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// JSONLogger writes one JSON object per log line. Printf cannot return an
+// error, so the first record that could not be written is kept for Err.
+type JSONLogger struct {
+	state *jsonLoggerState
+}
+
+type jsonLoggerState struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+type logEvent struct {
+	Event   string `json:"event"`
+	Source  string `json:"source,omitempty"`
+	Output  string `json:"output,omitempty"`
+	File    string `json:"file,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func NewJSONLogger(w io.Writer) JSONLogger {
+	return JSONLogger{
+		state: &jsonLoggerState{encoder: json.NewEncoder(w)},
+	}
+}
+
+func (l JSONLogger) Printf(s string, v ...interface{}) {
+	var event logEvent
+
+	switch s {
+	case replicatingLogFormat:
+		event = logEvent{Event: "replicating", Source: fmt.Sprint(v[0]), Output: fmt.Sprint(v[1])}
+	case addingLogFormat:
+		event = logEvent{Event: "adding", File: fmt.Sprint(v[0])}
+	case doneLogFormat:
+		event = logEvent{Event: "done"}
+	default:
+		event = logEvent{Event: "message", Message: strings.TrimSpace(fmt.Sprintf(s, v...))}
+	}
+
+	l.state.mutex.Lock()
+	defer l.state.mutex.Unlock()
+
+	err := l.state.encoder.Encode(event)
+	if err != nil && l.state.err == nil {
+		l.state.err = fmt.Errorf("could not write %s log record: %s", event.Event, err)
+	}
+}
+
+// Err returns the error of the first record that could not be written.
+func (l JSONLogger) Err() error {
+	l.state.mutex.Lock()
+	defer l.state.mutex.Unlock()
+
+	return l.state.err
+}
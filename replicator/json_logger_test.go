@@ -0,0 +1,66 @@
+package replicator_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+)
+
+var _ = Describe("json logger", func() {
+	It("emits one json object per event", func() {
+		buffer := &bytes.Buffer{}
+		logger := replicator.NewJSONLogger(buffer)
+
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		pathToTile := filepath.Join("..", "fixtures", "wrt.pivotal")
+		pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+		tileReplicator := replicator.NewTileReplicator(logger)
+		err = tileReplicator.Replicate(replicator.ApplicationConfig{
+			Path:   pathToTile,
+			Output: pathToOutputTile,
+			Name:   "Azure Sea",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+		Expect(lines).To(HaveLen(9))
+		Expect(string(lines[0])).To(MatchJSON(`{"event": "replicating", "source": "` + pathToTile + `", "output": "` + pathToOutputTile + `"}`))
+		Expect(string(lines[1])).To(MatchJSON(`{"event": "message", "message": "warning: p-windows-runtime is deprecated, use pas-windows instead"}`))
+		Expect(string(lines[2])).To(MatchJSON(`{"event": "adding", "file": "metadata/"}`))
+		Expect(string(lines[3])).To(MatchJSON(`{"event": "adding", "file": "metadata/p-windows-runtime.yml"}`))
+		Expect(string(lines[8])).To(MatchJSON(`{"event": "done"}`))
+		Expect(logger.Err()).NotTo(HaveOccurred())
+	})
+
+	It("wraps other log lines in a message event", func() {
+		buffer := &bytes.Buffer{}
+		logger := replicator.NewJSONLogger(buffer)
+
+		logger.Printf("something %s happened\n", "unusual")
+
+		Expect(buffer.String()).To(MatchJSON(`{"event": "message", "message": "something unusual happened"}`))
+	})
+	It("keeps the error of a record it could not write", func() {
+		logger := replicator.NewJSONLogger(failingWriter{})
+
+		logger.Printf("something %s happened\n", "unusual")
+		logger.Printf("done\n")
+
+		Expect(logger.Err()).To(MatchError("could not write message log record: disk full"))
+	})
+})
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
@@ -0,0 +1,241 @@
+package replicator
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const metadataWrapperKey = "metadata"
+
+var documentSeparatorRegexp = regexp.MustCompile(`(?m)^---([ \t].*)?$`)
+
+// splitYAMLDocuments splits contents before each "---" separator line, which
+// may carry a comment, a tag or content after the dashes. Each document keeps
+// its separator line. Documents holding nothing but whitespace and comments,
+// such as the one before a leading separator, are dropped.
+func splitYAMLDocuments(contents []byte) []string {
+	starts := []int{0}
+	for _, loc := range documentSeparatorRegexp.FindAllStringIndex(string(contents), -1) {
+		if loc[0] > 0 {
+			starts = append(starts, loc[0])
+		}
+	}
+	starts = append(starts, len(contents))
+
+	var documents []string
+	for i := 0; i < len(starts)-1; i++ {
+		document := string(contents[starts[i]:starts[i+1]])
+		if hasYAMLContent(document) {
+			documents = append(documents, document)
+		}
+	}
+
+	return documents
+}
+
+// hasYAMLContent reports whether document, after its separator, holds more
+// than whitespace and comments. Content on the separator line counts, a
+// comment there does not.
+func hasYAMLContent(document string) bool {
+	if loc := documentSeparatorRegexp.FindStringIndex(document); loc != nil && loc[0] == 0 {
+		document = strings.TrimPrefix(document[:loc[1]], "---") + document[loc[1]:]
+	}
+
+	for _, line := range strings.Split(document, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// productDocument splits metadata into its YAML documents and returns them
+// with the index of the product document, the first one with a name.
+// Metadata with a single document is returned whole, name or not.
+func productDocument(contents []byte) ([]string, int, error) {
+	documents := splitYAMLDocuments(contents)
+	if len(documents) < 2 {
+		return []string{string(contents)}, 0, nil
+	}
+
+	index := -1
+	for i, contents := range documents {
+		var document map[string]interface{}
+		err := yaml.Unmarshal([]byte(contents), &document)
+		if err != nil {
+			return nil, 0, fmt.Errorf("metadata document %d does not parse: %s", i+1, err)
+		}
+		metadata, _ := unwrapMetadata(document)
+		if _, ok := metadata["name"]; ok && index < 0 {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, 0, fmt.Errorf("none of the %d metadata documents has a name", len(documents))
+	}
+
+	return documents, index, nil
+}
+
+// parseProductMetadata parses the product document of contents and returns
+// it unwrapped.
+func parseProductMetadata(contents []byte) (map[string]interface{}, error) {
+	documents, index, err := productDocument(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	err = yaml.Unmarshal([]byte(documents[index]), &document)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _ := unwrapMetadata(document)
+
+	return metadata, nil
+}
+
+// joinYAMLDocuments is the inverse of splitYAMLDocuments. A single document
+// is returned as it is, and documents without a separator line, such as
+// those marshaled again, are given one.
+func joinYAMLDocuments(documents []string) []byte {
+	if len(documents) == 1 {
+		return []byte(documents[0])
+	}
+
+	var joined bytes.Buffer
+	for _, document := range documents {
+		if loc := documentSeparatorRegexp.FindStringIndex(document); loc == nil || loc[0] != 0 {
+			joined.WriteString("---\n")
+		}
+		joined.WriteString(document)
+		if !strings.HasSuffix(document, "\n") {
+			joined.WriteString("\n")
+		}
+	}
+
+	return joined.Bytes()
+}
+
+// visitMaps calls visit for node and every map nested beneath it.
+func visitMaps(node interface{}, visit func(map[interface{}]interface{})) {
+	switch n := node.(type) {
+	case map[interface{}]interface{}:
+		visit(n)
+		for _, value := range n {
+			visitMaps(value, visit)
+		}
+	case []interface{}:
+		for _, value := range n {
+			visitMaps(value, visit)
+		}
+	}
+}
+
+// visitBlueprints calls visit for every property blueprint beneath node, and
+// every selector option template, with its path: its name and the names
+// of the blueprints and templates enclosing it, joined with dots.
+func visitBlueprints(node interface{}, prefix string, visit func(path string, m map[interface{}]interface{})) {
+	switch n := node.(type) {
+	case map[interface{}]interface{}:
+		path := prefix
+		if name, ok := n["name"]; ok {
+			path = strings.TrimPrefix(fmt.Sprintf("%s.%v", prefix, name), ".")
+			visit(path, n)
+		}
+		visitBlueprints(n["property_blueprints"], path, visit)
+		visitBlueprints(n["option_templates"], path, visit)
+	case []interface{}:
+		for _, value := range n {
+			visitBlueprints(value, prefix, visit)
+		}
+	}
+}
+
+// mapStrings returns node with every string value beneath it passed through
+// fn. Map keys are left untouched.
+func mapStrings(node interface{}, fn func(string) string) interface{} {
+	switch n := node.(type) {
+	case string:
+		return fn(n)
+	case map[interface{}]interface{}:
+		for key, value := range n {
+			n[key] = mapStrings(value, fn)
+		}
+	case []interface{}:
+		for i, value := range n {
+			n[i] = mapStrings(value, fn)
+		}
+	}
+
+	return node
+}
+
+// mergeMetadata deep-merges overlay onto base. Maps are merged key by key;
+// any other overlay value, lists included, replaces what is in base.
+func mergeMetadata(base, overlay interface{}) interface{} {
+	baseMap, ok := base.(map[interface{}]interface{})
+	if !ok {
+		return overlay
+	}
+
+	overlayMap, ok := overlay.(map[interface{}]interface{})
+	if !ok {
+		return overlay
+	}
+
+	for key, value := range overlayMap {
+		baseMap[key] = mergeMetadata(baseMap[key], value)
+	}
+
+	return baseMap
+}
+
+// jsonValue converts the map[interface{}]interface{} values yaml.v2
+// produces into map[string]interface{} so node can be marshaled as JSON.
+func jsonValue(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, value := range n {
+			m[fmt.Sprintf("%v", key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range n {
+			n[i] = jsonValue(value)
+		}
+	}
+
+	return node
+}
+
+// unwrapMetadata returns the product metadata nested under a top-level
+// metadata key, which some packaging variants use instead of putting it at
+// the root. Metadata that is not wrapped is returned as is.
+func unwrapMetadata(document map[string]interface{}) (map[string]interface{}, bool) {
+	if _, ok := document["name"]; ok {
+		return document, false
+	}
+
+	wrapped, ok := document[metadataWrapperKey].(map[interface{}]interface{})
+	if !ok {
+		return document, false
+	}
+	if _, ok := wrapped["name"]; !ok {
+		return document, false
+	}
+
+	metadata := map[string]interface{}{}
+	for key, value := range wrapped {
+		metadata[fmt.Sprintf("%v", key)] = value
+	}
+
+	return metadata, true
+}
@@ -0,0 +1,58 @@
+package replicator
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ReplicateMetadataJSON returns the metadata Replicate would write for
+// config, serialized as JSON. Metadata with several YAML documents is
+// serialized as an array of them. Nothing is written to config.Output.
+func (t TileReplicator) ReplicateMetadataJSON(config ApplicationConfig) ([]byte, error) {
+	if err := config.validate(false); err != nil {
+		return nil, err
+	}
+
+	config, err := withResolvedName(config)
+	if err != nil {
+		return nil, err
+	}
+
+	srcTileZip, err := zip.OpenReader(config.Path)
+	if err != nil {
+		return nil, errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	metadata, err := t.readMetadata(&srcTileZip.Reader, config)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.member == "" {
+		return nil, fmt.Errorf("%s does not contain tile metadata", config.Path)
+	}
+
+	documents, _, err := productDocument(metadata.contents)
+	if err != nil {
+		return nil, err // not tested
+	}
+
+	var values []interface{}
+	for _, document := range documents {
+		var contents interface{}
+		err = yaml.Unmarshal([]byte(document), &contents)
+		if err != nil {
+			return nil, err // not tested
+		}
+		values = append(values, jsonValue(contents))
+	}
+	if len(values) == 1 {
+		return json.Marshal(values[0])
+	}
+
+	return json.Marshal(values)
+}
@@ -0,0 +1,84 @@
+package replicator_test
+
+import (
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("ReplicateMetadataJSON", func() {
+	var tileReplicator replicator.TileReplicator
+
+	BeforeEach(func() {
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+	})
+
+	It("returns the transformed metadata as json", func() {
+		pathToTile := writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: `---
+name: pas-windows
+label: Pivotal Application Service for Windows
+job_types:
+- name: windows_diego_cell
+  instance_definition:
+    default: 3
+`})
+
+		contents, err := tileReplicator.ReplicateMetadataJSON(replicator.ApplicationConfig{
+			Path: pathToTile,
+			Name: "Azure Sea",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(contents).To(MatchJSON(`{
+			"name": "pas-windows-azure-sea",
+			"label": "Pivotal Application Service for Windows (Azure Sea)",
+			"job_types": [{"name": "windows_diego_cell_azure_sea", "instance_definition": {"default": 3}}]
+		}`))
+	})
+
+	It("resolves the name as Replicate does", func() {
+		pathToTile := writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: `---
+name: pas-windows
+label: Pivotal Application Service for Windows
+`})
+
+		contents, err := tileReplicator.ReplicateMetadataJSON(replicator.ApplicationConfig{
+			Path:             pathToTile,
+			Name:             "Azure Sea",
+			Foundation:       "prod",
+			FoundationInName: true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(contents).To(MatchJSON(`{
+			"name": "pas-windows-azure-sea-prod",
+			"label": "Pivotal Application Service for Windows (Azure Sea prod)"
+		}`))
+	})
+
+	Context("when the tile has no metadata", func() {
+		It("returns an error", func() {
+			pathToTile := writeTile(tileEntry{name: "releases/some-release.tgz"})
+
+			_, err := tileReplicator.ReplicateMetadataJSON(replicator.ApplicationConfig{
+				Path: pathToTile,
+				Name: "Azure Sea",
+			})
+			Expect(err).To(MatchError(pathToTile + " does not contain tile metadata"))
+		})
+	})
+
+	Context("when the metadata cannot be transformed", func() {
+		It("returns an error", func() {
+			_, err := tileReplicator.ReplicateMetadataJSON(replicator.ApplicationConfig{
+				Path: filepath.Join("..", "fixtures", "invalid-no-label.pivotal"),
+				Name: "Azure Sea",
+			})
+			Expect(err).To(MatchError("Tile metadata file is missing required tile property 'label'"))
+		})
+	})
+})
@@ -0,0 +1,149 @@
+package replicator
+
+import (
+	"archive/zip"
+	"regexp"
+	"sort"
+)
+
+// Option customizes a TileReplicator. Options are applied in order by
+// NewTileReplicator; without any, the replicator behaves exactly as the
+// built-in defaults describe.
+type Option func(*TileReplicator)
+
+//go:generate counterfeiter -o ./fakes/tile_handler.go --fake-name TileHandler . TileHandler
+
+// TileHandler rewrites the marshaled metadata of the tiles it handles. A
+// registered handler is consulted before the built-in ones, so it can both
+// add support for new tiles and override the handling of supported ones.
+type TileHandler interface {
+	Handles(tileName string) bool
+	ReplaceProperties(metadata string, config ApplicationConfig) (string, error)
+}
+
+// WithHandler registers a TileHandler. Handlers registered earlier win.
+func WithHandler(handler TileHandler) Option {
+	return func(t *TileReplicator) {
+		t.handlers = append(t.handlers, handler)
+	}
+}
+
+//go:generate counterfeiter -o ./fakes/signature_verifier.go --fake-name SignatureVerifier . SignatureVerifier
+
+// SignatureVerifier checks the signature of the tile at path, returning an
+// error if it is missing or does not match the tile's contents.
+type SignatureVerifier interface {
+	Verify(path string) error
+}
+
+// WithSignatureVerifier sets the verifier used when
+// ApplicationConfig.VerifySignature is set.
+func WithSignatureVerifier(verifier SignatureVerifier) Option {
+	return func(t *TileReplicator) {
+		t.signatureVerifier = verifier
+	}
+}
+
+//go:generate counterfeiter -o ./fakes/secret_provider.go --fake-name SecretProvider . SecretProvider
+
+// SecretProvider looks up the value of a secret by key, so new secret
+// defaults never have to appear in a config file. The key is the property's
+// path: its name, after the names of any collections, selectors and option
+// templates it is nested in, joined with dots, such as bindings.token. The
+// values end up in the duplicate's metadata as plain text.
+type SecretProvider interface {
+	Get(key string) (string, error)
+}
+
+// WithSecretProvider sets the provider ApplicationConfig.ClearSecretDefaults
+// consults for new defaults.
+func WithSecretProvider(provider SecretProvider) Option {
+	return func(t *TileReplicator) {
+		t.secretProvider = provider
+	}
+}
+
+// MetadataPathPattern returns the pattern used to find a tile's product
+// metadata when no matcher is configured. Each call returns a new copy.
+func MetadataPathPattern() *regexp.Regexp {
+	return regexp.MustCompile(metadataRegexp.String())
+}
+
+// WithMetadataMatcher changes which member names are treated as product
+// metadata. ApplicationConfig.MetadataPath still takes precedence.
+func WithMetadataMatcher(re *regexp.Regexp) Option {
+	return func(t *TileReplicator) {
+		t.metadataMatcher = re
+	}
+}
+
+// WithDeterministic writes members sorted by name rather than in source
+// order, so tiles with the same contents zipped in different orders
+// replicate to byte-identical files.
+func WithDeterministic() Option {
+	return func(t *TileReplicator) {
+		t.deterministic = true
+	}
+}
+
+type nameTemplate struct {
+	tileName string
+	pattern  *regexp.Regexp
+	template string
+}
+
+// WithNameTemplate expands names matching pattern, when replicating
+// tileName, with regexp.ReplaceAllString's template syntax. For example,
+// pattern `^az(\d+)$` and template "iso-az$1" turn "az1" into "iso-az1".
+// Names that do not match are used as given, and the first matching
+// template wins.
+func WithNameTemplate(tileName string, pattern *regexp.Regexp, template string) Option {
+	return func(t *TileReplicator) {
+		t.nameTemplates = append(t.nameTemplates, nameTemplate{
+			tileName: tileName,
+			pattern:  pattern,
+			template: template,
+		})
+	}
+}
+
+func (t TileReplicator) expandName(tileName, name string) string {
+	for _, nameTemplate := range t.nameTemplates {
+		if nameTemplate.tileName == tileName && nameTemplate.pattern.MatchString(name) {
+			return nameTemplate.pattern.ReplaceAllString(name, nameTemplate.template)
+		}
+	}
+
+	return name
+}
+
+func (t TileReplicator) handler(tileName string) TileHandler {
+	for _, handler := range t.handlers {
+		if handler.Handles(tileName) {
+			return handler
+		}
+	}
+
+	return nil
+}
+
+func (t TileReplicator) matcher() *regexp.Regexp {
+	if t.metadataMatcher == nil {
+		return metadataRegexp
+	}
+
+	return t.metadataMatcher
+}
+
+func (t TileReplicator) orderedFiles(files []*zip.File) []*zip.File {
+	if !t.deterministic {
+		return files
+	}
+
+	sorted := append([]*zip.File(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
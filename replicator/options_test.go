@@ -0,0 +1,403 @@
+package replicator_test
+
+import (
+	"archive/zip"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf-experimental/gomegamatchers"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("tile replicator options", func() {
+	var (
+		pathToOutputTile string
+		logger           *fakes.Logger
+		replicate        func(pathToTile, name string)
+	)
+
+	BeforeEach(func() {
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+		logger = &fakes.Logger{}
+	})
+
+	Describe("WithHandler", func() {
+		var handler *fakes.TileHandler
+
+		BeforeEach(func() {
+			handler = &fakes.TileHandler{}
+			handler.HandlesStub = func(tileName string) bool {
+				return tileName == "some-custom-tile"
+			}
+			handler.ReplacePropertiesStub = func(metadata string, config replicator.ApplicationConfig) (string, error) {
+				return strings.Replace(metadata, "custom_job", "custom_job_"+config.Name, -1), nil
+			}
+		})
+
+		It("replicates tiles the handler handles", func() {
+			pathToTile := writeTile(tileEntry{name: "metadata/some-custom-tile.yml", contents: "name: some-custom-tile\nlabel: Custom\njob_types:\n- name: custom_job\n"})
+
+			err := replicator.NewTileReplicator(logger, replicator.WithHandler(handler)).Replicate(replicator.ApplicationConfig{
+				Path:   pathToTile,
+				Output: pathToOutputTile,
+				Name:   "blue",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(handler.ReplacePropertiesCallCount()).To(Equal(1))
+			_, config := handler.ReplacePropertiesArgsForCall(0)
+			Expect(config.Name).To(Equal("blue"))
+
+			contents := readTileFile(pathToOutputTile, "metadata/some-custom-tile.yml")
+			Expect(contents).To(ContainSubstring("name: some-custom-tile-blue"))
+			Expect(contents).To(ContainSubstring("name: custom_job_blue"))
+		})
+
+		It("leaves other tiles to the built-in handlers", func() {
+			err := replicator.NewTileReplicator(logger, replicator.WithHandler(handler)).Replicate(replicator.ApplicationConfig{
+				Path:   filepath.Join("..", "fixtures", "ist.pivotal"),
+				Output: pathToOutputTile,
+				Name:   "blue",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(handler.HandlesArgsForCall(0)).To(Equal("p-isolation-segment"))
+			Expect(handler.ReplacePropertiesCallCount()).To(Equal(0))
+			Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("isolated_diego_cell_blue"))
+		})
+
+		Context("when the handler fails", func() {
+			It("returns the error", func() {
+				handler.ReplacePropertiesStub = nil
+				handler.ReplacePropertiesReturns("", errors.New("handler failed"))
+
+				pathToTile := writeTile(tileEntry{name: "metadata/some-custom-tile.yml", contents: "name: some-custom-tile\nlabel: Custom\n"})
+
+				err := replicator.NewTileReplicator(logger, replicator.WithHandler(handler)).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+				})
+				Expect(err).To(MatchError("handler failed"))
+			})
+		})
+	})
+
+	Describe("MetadataPathPattern", func() {
+		It("matches the members the replicator treats as metadata", func() {
+			pattern := replicator.MetadataPathPattern()
+
+			Expect(pattern.MatchString("metadata/p-isolation-segment.yml")).To(BeTrue())
+			Expect(pattern.MatchString("releases/some-release.tgz")).To(BeFalse())
+		})
+
+		It("returns a copy that can be changed safely", func() {
+			replicator.MetadataPathPattern().Longest()
+
+			Expect(replicator.MetadataPathPattern()).NotTo(BeIdenticalTo(replicator.MetadataPathPattern()))
+		})
+	})
+
+	Describe("WithMetadataMatcher", func() {
+		It("treats matching members as the metadata", func() {
+			pathToTile := writeTile(tileEntry{name: "product/p-isolation-segment.yaml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+
+			err := replicator.NewTileReplicator(logger, replicator.WithMetadataMatcher(regexp.MustCompile(`^product/.*\.yaml$`))).Replicate(replicator.ApplicationConfig{
+				Path:   pathToTile,
+				Output: pathToOutputTile,
+				Name:   "blue",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(readTileFile(pathToOutputTile, "product/p-isolation-segment.yaml")).To(ContainSubstring("name: p-isolation-segment-blue"))
+		})
+	})
+
+	Describe("WithDeterministic", func() {
+		It("writes members sorted by name", func() {
+			pathToTile := writeTile(
+				tileEntry{name: "releases/b.tgz"},
+				tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+				tileEntry{name: "releases/a.tgz"},
+			)
+
+			err := replicator.NewTileReplicator(logger, replicator.WithDeterministic()).Replicate(replicator.ApplicationConfig{
+				Path:   pathToTile,
+				Output: pathToOutputTile,
+				Name:   "blue",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{"metadata/p-isolation-segment.yml", "releases/a.tgz", "releases/b.tgz"}))
+		})
+
+		It("keeps the source order by default", func() {
+			pathToTile := writeTile(
+				tileEntry{name: "releases/b.tgz"},
+				tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+				tileEntry{name: "releases/a.tgz"},
+			)
+
+			err := replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+				Path:   pathToTile,
+				Output: pathToOutputTile,
+				Name:   "blue",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{"releases/b.tgz", "metadata/p-isolation-segment.yml", "releases/a.tgz"}))
+		})
+	})
+
+	Describe("WithNameTemplate", func() {
+		var tileReplicator replicator.TileReplicator
+
+		BeforeEach(func() {
+			tileReplicator = replicator.NewTileReplicator(logger,
+				replicator.WithNameTemplate("p-isolation-segment", regexp.MustCompile(`^az(\d+)$`), "iso-az$1"),
+				replicator.WithNameTemplate("pas-windows", regexp.MustCompile(`^(\d{4})$`), "win$1"),
+			)
+			replicate = func(pathToTile, name string) {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   name,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("expands matching names with the tile's template", func() {
+			replicate(filepath.Join("..", "fixtures", "ist.pivotal"), "az2")
+
+			contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+			Expect(contents).To(ContainSubstring("name: p-isolation-segment-iso-az2"))
+			Expect(contents).To(ContainSubstring("isolated_diego_cell_iso_az2"))
+		})
+
+		It("reports the expanded name", func() {
+			result, err := tileReplicator.ReplicateWithResult(replicator.ApplicationConfig{
+				Path:   filepath.Join("..", "fixtures", "ist.pivotal"),
+				Output: pathToOutputTile,
+				Name:   "az2",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Name).To(Equal("iso-az2"))
+
+			zr, err := zip.OpenReader(pathToOutputTile)
+			Expect(err).NotTo(HaveOccurred())
+			defer zr.Close()
+
+			for _, file := range zr.File {
+				if file.Name == "metadata/p-isolation-segment.yml" {
+					Expect(file.Comment).To(Equal("transformed by the replicator with name iso-az2"))
+				}
+			}
+		})
+
+		It("uses each tile type's own template", func() {
+			replicate(filepath.Join("..", "fixtures", "wrt-2016.pivotal"), "2019")
+
+			contents := readTileFile(pathToOutputTile, "metadata/p-windows-runtime.yml")
+			Expect(contents).To(ContainSubstring("name: pas-windows-win2019"))
+			Expect(contents).To(ContainSubstring("windows_diego_cell_win2019"))
+		})
+
+		It("uses names that do not match as given", func() {
+			replicate(filepath.Join("..", "fixtures", "ist.pivotal"), "blue")
+
+			Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-blue"))
+		})
+
+		It("ignores templates for other tile types", func() {
+			replicate(filepath.Join("..", "fixtures", "wrt-2016.pivotal"), "az2")
+
+			Expect(readTileFile(pathToOutputTile, "metadata/p-windows-runtime.yml")).To(ContainSubstring("name: pas-windows-az2"))
+		})
+	})
+	Describe("WithSignatureVerifier", func() {
+		var (
+			pathToTile string
+			verifier   *fakes.SignatureVerifier
+		)
+
+		BeforeEach(func() {
+			pathToTile = filepath.Join("..", "fixtures", "ist.pivotal")
+			verifier = &fakes.SignatureVerifier{}
+		})
+
+		It("verifies the source and the replicated tile", func() {
+			var verifiedMembers [][]string
+			verifier.VerifyStub = func(path string) error {
+				verifiedMembers = append(verifiedMembers, tileFileNames(path))
+				return nil
+			}
+
+			err := replicator.NewTileReplicator(logger, replicator.WithSignatureVerifier(verifier)).Replicate(replicator.ApplicationConfig{
+				Path:            pathToTile,
+				Output:          pathToOutputTile,
+				Name:            "blue",
+				VerifySignature: true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(verifier.VerifyCallCount()).To(Equal(2))
+			Expect(verifier.VerifyArgsForCall(0)).To(Equal(pathToTile))
+			Expect(verifiedMembers[1]).To(Equal(tileFileNames(pathToOutputTile)))
+		})
+
+		It("does not write the tile when the source signature is invalid", func() {
+			verifier.VerifyReturns(errors.New("bad signature"))
+
+			err := replicator.NewTileReplicator(logger, replicator.WithSignatureVerifier(verifier)).Replicate(replicator.ApplicationConfig{
+				Path:            pathToTile,
+				Output:          pathToOutputTile,
+				Name:            "blue",
+				VerifySignature: true,
+			})
+			Expect(err).To(MatchError("signature of " + pathToTile + " is invalid: bad signature"))
+			Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+		})
+
+		It("does not keep a duplicate whose signature is invalid", func() {
+			verifier.VerifyReturnsOnCall(1, errors.New("bad signature"))
+
+			err := replicator.NewTileReplicator(logger, replicator.WithSignatureVerifier(verifier)).Replicate(replicator.ApplicationConfig{
+				Path:            pathToTile,
+				Output:          pathToOutputTile,
+				Name:            "blue",
+				VerifySignature: true,
+			})
+			Expect(err).To(MatchError("signature of the replicated tile is invalid: bad signature"))
+			Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+		})
+
+		It("returns an error when no verifier is set", func() {
+			err := replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+				Path:            pathToTile,
+				Output:          pathToOutputTile,
+				Name:            "blue",
+				VerifySignature: true,
+			})
+			Expect(err).To(MatchError("cannot verify signatures without a signature verifier"))
+		})
+	})
+
+	Describe("WithSecretProvider", func() {
+		var (
+			pathToTile string
+			provider   *fakes.SecretProvider
+		)
+
+		BeforeEach(func() {
+			pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: `---
+name: pas-windows
+label: Pivotal Application Service for Windows
+property_blueprints:
+- name: admin_password
+  type: secret
+  default: some-password
+- name: service_account
+  type: simple_credentials
+  default:
+    identity: admin
+    password: some-password
+- name: bindings
+  type: collection
+  property_blueprints:
+  - name: token
+    type: secret
+    default: some-token
+- name: token
+  type: secret
+  default: some-token
+`})
+			provider = &fakes.SecretProvider{}
+		})
+
+		It("defaults secret properties to the provider's values", func() {
+			provider.GetStub = func(key string) (string, error) {
+				switch key {
+				case "admin_password":
+					return "new-password", nil
+				case "bindings.token":
+					return "new-binding-token", nil
+				}
+				return "", nil
+			}
+
+			err := replicator.NewTileReplicator(logger, replicator.WithSecretProvider(provider)).Replicate(replicator.ApplicationConfig{
+				Path:                pathToTile,
+				Output:              pathToOutputTile,
+				Name:                "blue",
+				ClearSecretDefaults: true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var keys []string
+			for i := 0; i < provider.GetCallCount(); i++ {
+				keys = append(keys, provider.GetArgsForCall(i))
+			}
+			Expect(keys).To(ConsistOf("admin_password", "bindings.token", "token"))
+			Expect(readTileFile(pathToOutputTile, "metadata/pas-windows.yml")).To(gomegamatchers.MatchYAML(`---
+name: pas-windows-blue
+label: Pivotal Application Service for Windows (blue)
+property_blueprints:
+- name: admin_password
+  type: secret
+  default: new-password
+- name: service_account
+  type: simple_credentials
+- name: bindings
+  type: collection
+  property_blueprints:
+  - name: token
+    type: secret
+    default: new-binding-token
+- name: token
+  type: secret
+`))
+		})
+
+		It("redacts the provider's values in the dry-run diff", func() {
+			provider.GetReturns("new-password", nil)
+
+			err := replicator.NewTileReplicator(logger, replicator.WithSecretProvider(provider)).Replicate(replicator.ApplicationConfig{
+				Path:                pathToTile,
+				Output:              pathToOutputTile,
+				Name:                "blue",
+				ClearSecretDefaults: true,
+				DryRun:              true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			format, v := logger.PrintfArgsForCall(1)
+			diff := formatLogLine(format, v)
+			Expect(diff).To(ContainSubstring("+- default: <redacted>\n   name: admin_password"))
+			Expect(diff).NotTo(ContainSubstring("new-password"))
+		})
+
+		It("returns the provider's error", func() {
+			provider.GetReturns("", errors.New("vault is sealed"))
+
+			err := replicator.NewTileReplicator(logger, replicator.WithSecretProvider(provider)).Replicate(replicator.ApplicationConfig{
+				Path:                pathToTile,
+				Output:              pathToOutputTile,
+				Name:                "blue",
+				ClearSecretDefaults: true,
+			})
+			Expect(err).To(MatchError("could not get secret admin_password: vault is sealed"))
+			Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,194 @@
+package replicator
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"hash"
+	"hash/crc32"
+	"io"
+	"unicode/utf8"
+)
+
+// zipDeflateLevel, zipVersion20 and the flags match what archive/zip uses
+// for members written through CreateHeader.
+const (
+	zipDeflateLevel       = 5
+	zipVersion20          = 20
+	zipFlagDataDescriptor = 0x8
+	zipFlagUTF8           = 0x800
+)
+
+type compressedMember struct {
+	data             []byte
+	crc32            uint32
+	uncompressedSize uint64
+	checksum         []byte
+	err              error
+}
+
+// parallelCompressor deflates members on a pool of workers while the caller
+// writes them to the zip.Writer, which is not safe for concurrent use, in
+// order. At most twice as many members as there are workers are held in
+// memory at once.
+type parallelCompressor struct {
+	results []chan compressedMember
+	window  chan struct{}
+	done    chan struct{}
+}
+
+func newParallelCompressor(ctx context.Context, files []*zip.File, metadataMember string, config ApplicationConfig) *parallelCompressor {
+	workers := config.Workers
+	c := &parallelCompressor{
+		results: make([]chan compressedMember, len(files)),
+		window:  make(chan struct{}, 2*workers),
+		done:    make(chan struct{}),
+	}
+
+	for i, file := range files {
+		if file.Name != metadataMember && !isDirectory(file) && len(memberReplacements(file.Name, config.Replacements)) == 0 {
+			c.results[i] = make(chan compressedMember, 1)
+		}
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range files {
+			if c.results[i] == nil {
+				continue
+			}
+			select {
+			case c.window <- struct{}{}:
+			case <-c.done:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				c.results[i] <- compressMember(ctx, files[i], config)
+			}
+		}()
+	}
+
+	return c
+}
+
+func (c *parallelCompressor) compresses(i int) bool {
+	return c.results[i] != nil
+}
+
+func (c *parallelCompressor) write(dstTileZip *zip.Writer, header *zip.FileHeader, i int) ([]byte, error) {
+	member := <-c.results[i]
+	<-c.window
+	if member.err != nil {
+		return nil, member.err
+	}
+
+	header.CreatorVersion = header.CreatorVersion&0xff00 | zipVersion20
+	header.ReaderVersion = zipVersion20
+	header.Flags |= zipFlagDataDescriptor
+	if requiresUTF8Flag(header) {
+		header.Flags |= zipFlagUTF8
+	}
+	header.CRC32 = member.crc32
+	header.CompressedSize64 = uint64(len(member.data))
+	header.UncompressedSize64 = member.uncompressedSize
+
+	dstFile, err := dstTileZip.CreateRaw(header)
+	if err != nil {
+		return nil, err // not tested
+	}
+
+	_, err = dstFile.Write(member.data)
+	if err != nil {
+		return nil, err
+	}
+
+	return member.checksum, nil
+}
+
+func (c *parallelCompressor) stop() {
+	close(c.done)
+}
+
+func compressMember(ctx context.Context, srcFile *zip.File, config ApplicationConfig) compressedMember {
+	srcFileReader, err := srcFile.Open()
+	if err != nil {
+		return compressedMember{err: err} // not tested
+	}
+	defer srcFileReader.Close()
+
+	var buf bytes.Buffer
+	deflater, err := flate.NewWriter(&buf, zipDeflateLevel)
+	if err != nil {
+		return compressedMember{err: err} // not tested
+	}
+
+	crc := crc32.NewIEEE()
+	writers := []io.Writer{deflater, crc}
+	var memberChecksum hash.Hash
+	if config.WriteManifest {
+		memberChecksum = sha256.New()
+		writers = append(writers, memberChecksum)
+	}
+
+	n, err := copyBuffer(contextWriter{ctx, io.MultiWriter(writers...)}, srcFileReader, config.CopyBufferSize)
+	if err != nil {
+		return compressedMember{err: err}
+	}
+
+	err = deflater.Close()
+	if err != nil {
+		return compressedMember{err: err} // not tested
+	}
+
+	member := compressedMember{
+		data:             buf.Bytes(),
+		crc32:            crc.Sum32(),
+		uncompressedSize: uint64(n),
+	}
+	if config.WriteManifest {
+		member.checksum = memberChecksum.Sum(nil)
+	}
+
+	return member
+}
+
+// requiresUTF8Flag reports whether CreateHeader would set the UTF-8 flag for
+// header: its name or comment is valid UTF-8 that is not CP-437 compatible.
+func requiresUTF8Flag(header *zip.FileHeader) bool {
+	if header.NonUTF8 {
+		return false
+	}
+
+	nameValid, nameRequires := detectUTF8(header.Name)
+	commentValid, commentRequires := detectUTF8(header.Comment)
+
+	return (nameRequires || commentRequires) && nameValid && commentValid
+}
+
+func detectUTF8(s string) (valid, require bool) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r < 0x20 || r > 0x7d || r == 0x5c {
+			if !utf8.ValidRune(r) || (r == utf8.RuneError && size == 1) {
+				return false, false
+			}
+			require = true
+		}
+	}
+
+	return true, require
+}
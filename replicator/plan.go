@@ -0,0 +1,144 @@
+package replicator
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// ReplicationPlan describes what Replicate would do with a config. Plan
+// returns one and Apply carries it out.
+type ReplicationPlan struct {
+	Source           string            `json:"source"`
+	Output           string            `json:"output"`
+	Name             string            `json:"name"`
+	TileName         string            `json:"tile_name"`
+	ProductName      string            `json:"product_name"`
+	Label            string            `json:"label"`
+	JobRenames       map[string]string `json:"job_renames"`
+	Files            []string          `json:"files"`
+	Skipped          []string          `json:"skipped"`
+	Replacements     map[string]int    `json:"replacements"`
+	MetadataChecksum string            `json:"metadata_checksum"`
+
+	// Options reports whether Plan was given options other than Path,
+	// Output and Name. They cannot be encoded, so Apply refuses a decoded
+	// plan that had them.
+	Options bool `json:"options"`
+
+	config  ApplicationConfig
+	planned bool
+}
+
+// Plan works out everything Replicate would do with config, without writing
+// anything. Replacements counts the occurrences of each token the built-in
+// renames replace, as ReplacementCounts does.
+func (t TileReplicator) Plan(config ApplicationConfig) (ReplicationPlan, error) {
+	if err := config.Validate(); err != nil {
+		return ReplicationPlan{}, err
+	}
+
+	config, err := withResolvedName(config)
+	if err != nil {
+		return ReplicationPlan{}, err
+	}
+
+	srcTileZip, err := zip.OpenReader(config.Path)
+	if err != nil {
+		return ReplicationPlan{}, errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	metadata, err := t.readMetadata(&srcTileZip.Reader, config)
+	if err != nil {
+		return ReplicationPlan{}, err
+	}
+
+	if fi, err := os.Stat(config.Output); err == nil && fi.IsDir() {
+		config.Output = filepath.Join(config.Output, metadata.productName+t.outputExtension(config))
+	}
+
+	plan := ReplicationPlan{
+		Source:       config.Path,
+		Output:       config.Output,
+		Name:         config.Name,
+		TileName:     metadata.tileName,
+		ProductName:  metadata.productName,
+		JobRenames:   map[string]string{},
+		Replacements: map[string]int{},
+		Options:      hasOptions(config),
+		config:       config,
+		planned:      true,
+	}
+
+	if metadata.member != "" {
+		product, err := parseProductMetadata(metadata.contents)
+		if err != nil {
+			return ReplicationPlan{}, err // not tested
+		}
+		plan.Label = fmt.Sprintf("%v", product["label"])
+
+		checksum := sha256.Sum256(metadata.contents)
+		plan.MetadataChecksum = hex.EncodeToString(checksum[:])
+
+		if t.handler(metadata.tileName) == nil {
+			renamesConfig := config
+			renamesConfig.Name = t.expandName(metadata.tileName, config.Name)
+			plan.JobRenames = t.jobRenames(metadata.tileName, renamesConfig)
+		}
+		plan.Replacements = t.replacementCounts(metadata.original, metadata.tileName, config)
+	}
+
+	files, _ := t.copiedFiles(&srcTileZip.Reader, metadata.member, config)
+	copied := map[string]bool{}
+	for _, srcFile := range files {
+		plan.Files = append(plan.Files, srcFile.Name)
+		copied[srcFile.Name] = true
+	}
+	for _, srcFile := range srcTileZip.File {
+		if !copied[srcFile.Name] {
+			plan.Skipped = append(plan.Skipped, srcFile.Name)
+		}
+	}
+
+	return plan, nil
+}
+
+// Apply replicates the tile as planned. It fails, without writing, if the
+// source no longer produces the planned metadata and members. A plan
+// decoded from JSON keeps only its Source, Output and Name, so Apply
+// refuses one that was planned with other options.
+func (t TileReplicator) Apply(plan ReplicationPlan) error {
+	if !plan.planned && plan.Options {
+		return fmt.Errorf("plan has no options, but %s was planned with some; plan it again instead of decoding the plan", plan.Source)
+	}
+
+	config := plan.config
+	config.Path = plan.Source
+	config.Output = plan.Output
+	config.Name = plan.Name
+
+	current, err := t.Plan(config)
+	if err != nil {
+		return err
+	}
+	if current.MetadataChecksum != plan.MetadataChecksum || !reflect.DeepEqual(current.Files, plan.Files) {
+		return fmt.Errorf("%s has changed since it was planned", plan.Source)
+	}
+
+	return t.Replicate(config)
+}
+
+// hasOptions reports whether config sets anything but Path, Output and Name.
+func hasOptions(config ApplicationConfig) bool {
+	config.Path = ""
+	config.Output = ""
+	config.Name = ""
+
+	return !reflect.DeepEqual(config, ApplicationConfig{})
+}
@@ -0,0 +1,133 @@
+package replicator_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("Plan and Apply", func() {
+	var (
+		tileReplicator   replicator.TileReplicator
+		pathToTile       string
+		pathToOutputTile string
+	)
+
+	BeforeEach(func() {
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+		pathToTile = filepath.Join("..", "fixtures", "ist.pivotal")
+
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+	})
+
+	It("plans the replication without writing anything", func() {
+		plan, err := tileReplicator.Plan(replicator.ApplicationConfig{
+			Path:     pathToTile,
+			Output:   pathToOutputTile,
+			Name:     "blue",
+			SlimMode: true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(plan.Source).To(Equal(pathToTile))
+		Expect(plan.Output).To(Equal(pathToOutputTile))
+		Expect(plan.Name).To(Equal("blue"))
+		Expect(plan.TileName).To(Equal("p-isolation-segment"))
+		Expect(plan.ProductName).To(Equal("p-isolation-segment-blue"))
+		Expect(plan.Label).To(Equal("PCF Isolation Segment (blue)"))
+		Expect(plan.JobRenames).To(Equal(map[string]string{
+			"isolated_router":     "isolated_router_blue",
+			"isolated_diego_cell": "isolated_diego_cell_blue",
+			"isolated_ha_proxy":   "isolated_ha_proxy_blue",
+		}))
+		Expect(plan.Files).To(ContainElement("metadata/p-isolation-segment.yml"))
+		Expect(plan.Skipped).To(Equal([]string{"releases/some-release.tgz"}))
+		Expect(plan.Replacements).To(HaveKey("isolated_diego_cell"))
+		Expect(plan.MetadataChecksum).NotTo(BeEmpty())
+
+		Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+	})
+
+	It("applies a plan as Replicate would", func() {
+		config := replicator.ApplicationConfig{
+			Path:   pathToTile,
+			Output: pathToOutputTile,
+			Name:   "blue",
+		}
+
+		plan, err := tileReplicator.Plan(config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tileReplicator.Apply(plan)).To(Succeed())
+
+		Expect(tileFileNames(pathToOutputTile)).To(HaveLen(len(plan.Files)))
+		Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-blue"))
+	})
+
+	It("applies a plan decoded from JSON", func() {
+		plan, err := tileReplicator.Plan(replicator.ApplicationConfig{
+			Path:   pathToTile,
+			Output: pathToOutputTile,
+			Name:   "blue",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		encoded, err := json.Marshal(plan)
+		Expect(err).NotTo(HaveOccurred())
+
+		var decoded replicator.ReplicationPlan
+		Expect(json.Unmarshal(encoded, &decoded)).To(Succeed())
+		Expect(decoded.ProductName).To(Equal("p-isolation-segment-blue"))
+		Expect(decoded.Options).To(BeFalse())
+
+		Expect(tileReplicator.Apply(decoded)).To(Succeed())
+		Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-blue"))
+	})
+
+	It("refuses a decoded plan that was made with options", func() {
+		plan, err := tileReplicator.Plan(replicator.ApplicationConfig{
+			Path:     pathToTile,
+			Output:   pathToOutputTile,
+			Name:     "blue",
+			SlimMode: true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Options).To(BeTrue())
+
+		encoded, err := json.Marshal(plan)
+		Expect(err).NotTo(HaveOccurred())
+
+		var decoded replicator.ReplicationPlan
+		Expect(json.Unmarshal(encoded, &decoded)).To(Succeed())
+
+		Expect(tileReplicator.Apply(decoded)).To(MatchError(fmt.Sprintf("plan has no options, but %s was planned with some; plan it again instead of decoding the plan", pathToTile)))
+		Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+		Expect(tileReplicator.Apply(plan)).To(Succeed())
+	})
+
+	It("refuses a plan the source no longer matches", func() {
+		pathToChangingTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+
+		plan, err := tileReplicator.Plan(replicator.ApplicationConfig{
+			Path:   pathToChangingTile,
+			Output: pathToOutputTile,
+			Name:   "blue",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		changed, err := ioutil.ReadFile(writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment 2\n"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(pathToChangingTile, changed, 0644)).To(Succeed())
+
+		Expect(tileReplicator.Apply(plan)).To(MatchError(pathToChangingTile + " has changed since it was planned"))
+		Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,56 @@
+package replicator
+
+import "sync"
+
+// ProgressAggregator sums the progress of several replications, which may
+// run concurrently, into a single report.
+type ProgressAggregator struct {
+	report func(copied, total int64)
+
+	mutex  sync.Mutex
+	copied map[string]int64
+	totals map[string]int64
+}
+
+// NewProgressAggregator returns an aggregator that calls report with the
+// bytes copied and the total bytes across every tracked replication.
+// report is never called concurrently.
+func NewProgressAggregator(report func(copied, total int64)) *ProgressAggregator {
+	return &ProgressAggregator{
+		report: report,
+		copied: map[string]int64{},
+		totals: map[string]int64{},
+	}
+}
+
+// Expect counts total bytes for key before its replication starts, so
+// early reports already reflect the size of the whole batch.
+// TileReplicator.ProgressTotal gives the total for a tile.
+func (a *ProgressAggregator) Expect(key string, total int64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.totals[key] = total
+}
+
+// Progress returns a callback for ApplicationConfig.Progress that records
+// progress under key, which must be unique within the batch.
+func (a *ProgressAggregator) Progress(key string) func(copied, total int64) {
+	return func(copied, total int64) {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+
+		a.copied[key] = copied
+		a.totals[key] = total
+
+		var batchCopied, batchTotal int64
+		for _, n := range a.copied {
+			batchCopied += n
+		}
+		for _, n := range a.totals {
+			batchTotal += n
+		}
+
+		a.report(batchCopied, batchTotal)
+	}
+}
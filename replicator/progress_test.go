@@ -0,0 +1,119 @@
+package replicator_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("ProgressAggregator", func() {
+	It("sums the progress of concurrent replications", func() {
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		var (
+			reportMutex       sync.Mutex
+			lastCopied, total int64
+			reports           int
+		)
+		aggregator := replicator.NewProgressAggregator(func(copied, batchTotal int64) {
+			reportMutex.Lock()
+			defer reportMutex.Unlock()
+
+			Expect(copied).To(BeNumerically(">=", lastCopied))
+			Expect(copied).To(BeNumerically("<=", batchTotal))
+			lastCopied, total = copied, batchTotal
+			reports++
+		})
+
+		var expectedTotal int64
+		var pathsToTile []string
+		for i := 0; i < 4; i++ {
+			pathToTile := writeTile(
+				tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+				tileEntry{name: "releases/a.tgz", contents: strings.Repeat("a", 1000*(i+1))},
+				tileEntry{name: "releases/b.tgz", contents: strings.Repeat("b", 500)},
+			)
+			pathsToTile = append(pathsToTile, pathToTile)
+
+			size, err := replicator.EstimateOutputSize(pathToTile)
+			Expect(err).NotTo(HaveOccurred())
+			aggregator.Expect(pathToTile, size)
+			expectedTotal += size
+		}
+
+		tileReplicator := replicator.NewTileReplicator(&fakes.Logger{})
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(pathsToTile))
+		for i, pathToTile := range pathsToTile {
+			wg.Add(1)
+			go func(i int, pathToTile string) {
+				defer wg.Done()
+				defer GinkgoRecover()
+
+				errs <- tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:     pathToTile,
+					Output:   filepath.Join(tempDir, fmt.Sprintf("tile-%d.pivotal", i)),
+					Name:     fmt.Sprintf("tile %d", i),
+					Quiet:    true,
+					Progress: aggregator.Progress(pathToTile),
+				})
+			}(i, pathToTile)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(reports).To(Equal(12))
+		Expect(total).To(Equal(expectedTotal))
+		Expect(lastCopied).To(Equal(total))
+	})
+	It("expects the total that Progress reports", func() {
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		pathToTile := writeTile(
+			tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+			tileEntry{name: "releases/a.tgz", contents: strings.Repeat("a", 1000)},
+		)
+		config := replicator.ApplicationConfig{
+			Path:     pathToTile,
+			Output:   filepath.Join(tempDir, "tile.pivotal"),
+			Name:     "blue",
+			SlimMode: true,
+		}
+
+		var totals []int64
+		aggregator := replicator.NewProgressAggregator(func(copied, total int64) {
+			totals = append(totals, total)
+		})
+
+		tileReplicator := replicator.NewTileReplicator(&fakes.Logger{})
+		total, err := tileReplicator.ProgressTotal(config)
+		Expect(err).NotTo(HaveOccurred())
+		size, err := replicator.EstimateOutputSize(pathToTile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(total).To(BeNumerically("<", size))
+		aggregator.Expect(pathToTile, total)
+
+		config.Progress = aggregator.Progress(pathToTile)
+		Expect(tileReplicator.Replicate(config)).To(Succeed())
+
+		Expect(totals).NotTo(BeEmpty())
+		for _, reported := range totals {
+			Expect(reported).To(Equal(total))
+		}
+	})
+})
@@ -0,0 +1,80 @@
+package replicator
+
+import (
+	"fmt"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Rename gives the tile at path, which may itself be a duplicate, the name
+// newName in place. The metadata of a duplicate is first returned to the
+// original tile's name, label, job types and on-demand plan, broker and
+// service names, so those already suffixed with the previous name end up
+// with the new suffix rather than both.
+func (t TileReplicator) Rename(path, newName string) error {
+	return t.Replicate(ApplicationConfig{
+		Path:     path,
+		Output:   path,
+		Name:     newName,
+		renaming: true,
+	})
+}
+
+// unreplicateMetadata undoes the renames a previous replication made to the
+// product name, label and job types, and for on-demand tiles to the plans,
+// broker and service. Metadata of a tile that was never replicated is
+// returned unchanged.
+func (t TileReplicator) unreplicateMetadata(contents []byte) ([]byte, error) {
+	var document map[string]interface{}
+	err := yaml.Unmarshal(contents, &document)
+	if err != nil {
+		return nil, err
+	}
+	metadata, wrapped := unwrapMetadata(document)
+
+	name := fmt.Sprintf("%v", metadata["name"])
+	var tileName string
+	for _, supportedTile := range supportedTiles {
+		if strings.HasPrefix(name, supportedTile+"-") {
+			tileName = supportedTile
+		}
+	}
+	if tileName == "" {
+		return contents, nil
+	}
+	previousName := strings.TrimPrefix(name, tileName+"-")
+	previousConfig := ApplicationConfig{Name: previousName}
+
+	metadata["name"] = tileName
+	if label, ok := metadata["label"].(string); ok && strings.HasSuffix(label, ")") {
+		if i := strings.LastIndex(label, " ("); i > 0 {
+			metadata["label"] = label[:i]
+		}
+	}
+	if contains(onDemandTiles, tileName) {
+		renamePlans(metadata, func(plan string) string {
+			return strings.TrimSuffix(plan, "_"+t.formatName(previousConfig))
+		})
+	}
+
+	if wrapped {
+		document[metadataWrapperKey] = metadata
+	} else {
+		document = metadata
+	}
+	contents, err = yaml.Marshal(document)
+	if err != nil {
+		return nil, err // not tested
+	}
+
+	unreplicated := string(contents)
+	for original, renamed := range t.jobRenames(tileName, previousConfig) {
+		unreplicated = strings.Replace(unreplicated, renamed, original, -1)
+	}
+	if tileName == "mongodb-on-demand" {
+		unreplicated = unreplaceMongoDbDNS(unreplicated, t.formatName(previousConfig))
+	}
+
+	return []byte(unreplicated), nil
+}
@@ -0,0 +1,125 @@
+package replicator_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+	"github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("Rename", func() {
+	var (
+		tileReplicator replicator.TileReplicator
+		pathToTile     string
+		tempDir        string
+	)
+
+	BeforeEach(func() {
+		pathToTile = filepath.Join("..", "fixtures", "ist.pivotal")
+
+		var err error
+		tempDir, err = ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+	})
+
+	It("renames a duplicate as if it had been replicated from the original", func() {
+		pathToDuplicate := filepath.Join(tempDir, "duplicate.pivotal")
+		err := tileReplicator.Replicate(replicator.ApplicationConfig{
+			Path:   pathToTile,
+			Output: pathToDuplicate,
+			Name:   "Magenta Foo",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		pathToExpected := filepath.Join(tempDir, "expected.pivotal")
+		err = tileReplicator.Replicate(replicator.ApplicationConfig{
+			Path:   pathToTile,
+			Output: pathToExpected,
+			Name:   "Cyan",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = tileReplicator.Rename(pathToDuplicate, "Cyan")
+		Expect(err).NotTo(HaveOccurred())
+
+		renamed := readTileFile(pathToDuplicate, "metadata/p-isolation-segment.yml")
+		Expect(renamed).To(ContainSubstring("name: p-isolation-segment-cyan\n"))
+		Expect(renamed).To(ContainSubstring("label: PCF Isolation Segment (Cyan)\n"))
+		Expect(renamed).To(ContainSubstring("name: isolated_router_cyan\n"))
+		Expect(renamed).NotTo(ContainSubstring("magenta"))
+		Expect(renamed).To(gomegamatchers.MatchYAML(readTileFile(pathToExpected, "metadata/p-isolation-segment.yml")))
+		Expect(tileFileNames(pathToDuplicate)).To(Equal(tileFileNames(pathToExpected)))
+	})
+
+	It("renames a mongodb duplicate's plans, broker and service", func() {
+		pathToTile = writeTile(tileEntry{name: "metadata/mongodb-on-demand.yml", contents: `---
+name: mongodb-on-demand
+label: MongoDB Enterprise Service
+property_blueprints:
+- name: plan_collection
+  type: collection
+  default:
+  - plan_name: small
+job_types:
+- name: mongodb_broker
+  manifest: |
+    broker_name: mongodb-odb
+    service_name: mongodb-odb
+  service_catalog:
+    plans:
+    - name: standalone
+runtime_configs:
+- name: mongodb-dns-aliases
+  runtime_config: |
+    releases:
+    - name: bosh-dns-aliases
+      version: 1.2.6
+`})
+
+		pathToDuplicate := filepath.Join(tempDir, "duplicate.pivotal")
+		err := tileReplicator.Replicate(replicator.ApplicationConfig{
+			Path:   pathToTile,
+			Output: pathToDuplicate,
+			Name:   "Blue Foo",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		pathToExpected := filepath.Join(tempDir, "expected.pivotal")
+		err = tileReplicator.Replicate(replicator.ApplicationConfig{
+			Path:   pathToTile,
+			Output: pathToExpected,
+			Name:   "Cyan",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = tileReplicator.Rename(pathToDuplicate, "Cyan")
+		Expect(err).NotTo(HaveOccurred())
+
+		renamed := readTileFile(pathToDuplicate, "metadata/mongodb-on-demand.yml")
+		Expect(renamed).To(ContainSubstring("broker_name: mongodb-odb-cyan\n"))
+		Expect(renamed).To(ContainSubstring("plan_name: small_cyan\n"))
+		Expect(renamed).NotTo(ContainSubstring("blue_foo"))
+		Expect(renamed).To(gomegamatchers.MatchYAML(readTileFile(pathToExpected, "metadata/mongodb-on-demand.yml")))
+	})
+
+	It("renames a tile that was never replicated", func() {
+		pathToCopy := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\njob_types:\n- name: isolated_router\n"})
+
+		err := tileReplicator.Rename(pathToCopy, "Cyan")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(readTileFile(pathToCopy, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(`
+name: p-isolation-segment-cyan
+label: PCF Isolation Segment (Cyan)
+job_types:
+- name: isolated_router_cyan
+`))
+	})
+})
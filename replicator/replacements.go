@@ -0,0 +1,137 @@
+package replicator
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	yamlFileRegexp    = regexp.MustCompile(`\.ya?ml$`)
+	blockScalarRegexp = regexp.MustCompile(`(^|:|-)\s+[|>][-+0-9]*\s*$`)
+)
+
+// ReplacementRule replaces every occurrence of Old with New. Rules without
+// a FileGlob apply to the metadata after the built-in renames. Rules with a
+// FileGlob apply instead to the other members whose names match it, in
+// path.Match syntax, where * does not cross a "/". A "**" element matches
+// any number of directories, so migrations/** matches every member beneath
+// migrations.
+type ReplacementRule struct {
+	Old      string
+	New      string
+	FileGlob string
+}
+
+func checkReplacements(rules []ReplacementRule) error {
+	for _, rule := range rules {
+		if rule.FileGlob == "" {
+			continue
+		}
+		for _, element := range strings.Split(rule.FileGlob, "/") {
+			if _, err := path.Match(element, ""); err != nil {
+				return fmt.Errorf("invalid file glob %s: %s", rule.FileGlob, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// memberReplacements returns the rules that apply to the member name, or the
+// metadata rules when name is empty.
+func memberReplacements(name string, rules []ReplacementRule) []ReplacementRule {
+	var matching []ReplacementRule
+	for _, rule := range rules {
+		if name == "" && rule.FileGlob == "" {
+			matching = append(matching, rule)
+			continue
+		}
+		if name != "" && rule.FileGlob != "" {
+			if matchGlob(strings.Split(rule.FileGlob, "/"), strings.Split(name, "/")) {
+				matching = append(matching, rule)
+			}
+		}
+	}
+
+	return matching
+}
+
+// matchGlob matches name against pattern one path element at a time. A "**"
+// element matches any number of name elements, none included.
+func matchGlob(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchGlob(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+
+	return matchGlob(pattern[1:], name[1:])
+}
+
+func applyReplacements(contents string, rules []ReplacementRule) string {
+	for _, rule := range rules {
+		contents = strings.Replace(contents, rule.Old, rule.New, -1)
+	}
+
+	return contents
+}
+
+// Substitution replaces every occurrence of From with To in the metadata.
+type Substitution struct {
+	From string
+	To   string
+}
+
+func applySubstitutions(contents string, substitutions []Substitution) string {
+	for _, substitution := range substitutions {
+		contents = strings.Replace(contents, substitution.From, substitution.To, -1)
+	}
+
+	return contents
+}
+
+// stripYAMLComments drops the lines that are only a comment. Comments after
+// a value are kept, since a # there may be part of the value, and so are
+// lines inside block scalars.
+func stripYAMLComments(contents string) string {
+	var kept []string
+	blockIndent := -1
+	for _, line := range strings.Split(contents, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+
+		if blockIndent >= 0 {
+			if trimmed == "" || indent > blockIndent {
+				kept = append(kept, line)
+				continue
+			}
+			blockIndent = -1
+		}
+
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if blockScalarRegexp.MatchString(line) {
+			blockIndent = indent
+		}
+		kept = append(kept, line)
+	}
+
+	return strings.Join(kept, "\n")
+}
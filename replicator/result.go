@@ -0,0 +1,66 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+type ReplicationResult struct {
+	Source      string
+	Output      string
+	Name        string
+	TileName    string
+	ProductName string
+	FilesCopied int
+	Size        int64
+	Checksum    string
+	Started     time.Time
+	Duration    time.Duration
+
+	manifest []string
+}
+
+type auditRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Source    string    `json:"source"`
+	Output    string    `json:"output"`
+	Name      string    `json:"name"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+	Checksum  string    `json:"checksum,omitempty"`
+}
+
+func writeAuditRecord(w io.Writer, result ReplicationResult, err error) error {
+	record := auditRecord{
+		Timestamp: result.Started.UTC(),
+		Source:    result.Source,
+		Output:    result.Output,
+		Name:      result.Name,
+		Result:    "success",
+		Checksum:  result.Checksum,
+	}
+
+	if err != nil {
+		record.Result = "failure"
+		record.Error = err.Error()
+		record.Checksum = ""
+	}
+
+	return json.NewEncoder(w).Encode(record)
+}
+
+// PrintSummary writes a human readable report of a replication to w.
+func PrintSummary(w io.Writer, result ReplicationResult) error {
+	_, err := fmt.Fprintf(w, `tile:         %s
+product name: %s
+name:         %s
+output:       %s
+files copied: %d
+size:         %d bytes
+checksum:     %s
+duration:     %s
+`, result.TileName, result.ProductName, result.Name, result.Output, result.FilesCopied, result.Size, result.Checksum, result.Duration.Round(time.Millisecond))
+	return err
+}
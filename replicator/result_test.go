@@ -0,0 +1,132 @@
+package replicator_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("replication result", func() {
+	var (
+		tileReplicator   replicator.TileReplicator
+		pathToTile       string
+		pathToOutputTile string
+	)
+
+	BeforeEach(func() {
+		pathToTile = filepath.Join("..", "fixtures", "ist.pivotal")
+
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+	})
+
+	It("describes the replication", func() {
+		result, err := tileReplicator.ReplicateWithResult(replicator.ApplicationConfig{
+			Path:   pathToTile,
+			Output: pathToOutputTile,
+			Name:   "Magenta Foo",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		contents, err := ioutil.ReadFile(pathToOutputTile)
+		Expect(err).NotTo(HaveOccurred())
+		checksum := sha256.Sum256(contents)
+
+		Expect(result.Source).To(Equal(pathToTile))
+		Expect(result.Output).To(Equal(pathToOutputTile))
+		Expect(result.Name).To(Equal("Magenta Foo"))
+		Expect(result.TileName).To(Equal("p-isolation-segment"))
+		Expect(result.ProductName).To(Equal("p-isolation-segment-magenta-foo"))
+		Expect(result.FilesCopied).To(Equal(6))
+		Expect(result.Size).To(Equal(int64(len(contents))))
+		Expect(result.Checksum).To(Equal(hex.EncodeToString(checksum[:])))
+	})
+
+	Context("when an audit writer is given", func() {
+		var auditLog *bytes.Buffer
+
+		BeforeEach(func() {
+			auditLog = &bytes.Buffer{}
+		})
+
+		It("writes a record for a successful replication", func() {
+			result, err := tileReplicator.ReplicateWithResult(replicator.ApplicationConfig{
+				Path:        pathToTile,
+				Output:      pathToOutputTile,
+				Name:        "Magenta Foo",
+				AuditWriter: auditLog,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var record map[string]interface{}
+			Expect(json.Unmarshal(auditLog.Bytes(), &record)).To(Succeed())
+
+			Expect(record).To(HaveKey("timestamp"))
+			Expect(record).To(HaveKeyWithValue("source", pathToTile))
+			Expect(record).To(HaveKeyWithValue("output", pathToOutputTile))
+			Expect(record).To(HaveKeyWithValue("name", "Magenta Foo"))
+			Expect(record).To(HaveKeyWithValue("result", "success"))
+			Expect(record).To(HaveKeyWithValue("checksum", result.Checksum))
+			Expect(record).NotTo(HaveKey("error"))
+		})
+
+		It("writes a record for a failed replication", func() {
+			err := tileReplicator.Replicate(replicator.ApplicationConfig{
+				Path:        "some-bogus-path",
+				Output:      pathToOutputTile,
+				Name:        "Magenta Foo",
+				AuditWriter: auditLog,
+			})
+			Expect(err).To(HaveOccurred())
+
+			var record map[string]interface{}
+			Expect(json.Unmarshal(auditLog.Bytes(), &record)).To(Succeed())
+
+			Expect(record).To(HaveKeyWithValue("result", "failure"))
+			Expect(record).To(HaveKeyWithValue("error", "could not open source zip file"))
+			Expect(record).NotTo(HaveKey("checksum"))
+		})
+	})
+
+	Describe("PrintSummary", func() {
+		It("writes a human readable summary", func() {
+			summary := &bytes.Buffer{}
+
+			err := replicator.PrintSummary(summary, replicator.ReplicationResult{
+				Source:      pathToTile,
+				Output:      "/tmp/p-isolation-segment-blue.pivotal",
+				Name:        "blue",
+				TileName:    "p-isolation-segment",
+				ProductName: "p-isolation-segment-blue",
+				FilesCopied: 6,
+				Size:        1024,
+				Checksum:    "abc123",
+				Duration:    1500*time.Millisecond + 400*time.Microsecond,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(summary.String()).To(Equal(`tile:         p-isolation-segment
+product name: p-isolation-segment-blue
+name:         blue
+output:       /tmp/p-isolation-segment-blue.pivotal
+files copied: 6
+size:         1024 bytes
+checksum:     abc123
+duration:     1.5s
+`))
+		})
+	})
+})
@@ -0,0 +1,197 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// validateMetadataSchema checks metadata against the JSON schema at
+// schemaPath. Only the commonly used subset of JSON Schema is understood:
+// type, required, properties, additionalProperties, items, enum and
+// pattern. Other keywords are ignored.
+func validateMetadataSchema(metadata []byte, schemaPath string) error {
+	schemaContents, err := ioutil.ReadFile(schemaPath)
+	if err != nil {
+		return fmt.Errorf("could not read schema: %s", err)
+	}
+
+	var schema interface{}
+	err = json.Unmarshal(schemaContents, &schema)
+	if err != nil {
+		return fmt.Errorf("could not parse schema: %s", err)
+	}
+
+	var document interface{}
+	err = yaml.Unmarshal(metadata, &document)
+	if err != nil {
+		return err // not tested
+	}
+
+	problems := validateSchemaValue(schema, jsonValue(document), "")
+	if len(problems) > 0 {
+		return fmt.Errorf("metadata does not match schema %s: %s", schemaPath, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func validateSchemaValue(schemaNode, value interface{}, path string) []string {
+	schema, ok := schemaNode.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	location := path
+	if location == "" {
+		location = "/"
+	}
+
+	if types, ok := schema["type"]; ok && !matchesSchemaType(types, value) {
+		return []string{fmt.Sprintf("%s: expected %s", location, schemaTypeNames(types))}
+	}
+
+	var problems []string
+	if enum, ok := schema["enum"].([]interface{}); ok && !inSchemaEnum(enum, value) {
+		problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", location, value, enum))
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		if s, ok := value.(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid pattern %s", location, pattern))
+			} else if !re.MatchString(s) {
+				problems = append(problems, fmt.Sprintf("%s: %q does not match %s", location, s, pattern))
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, key := range required {
+				if _, ok := v[fmt.Sprintf("%v", key)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: missing required property %v", location, key))
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		var keys []string
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			propertySchema, ok := properties[key]
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					problems = append(problems, fmt.Sprintf("%s: unexpected property %s", location, key))
+				}
+				continue
+			}
+			problems = append(problems, validateSchemaValue(propertySchema, v[key], path+"/"+key)...)
+		}
+	case []interface{}:
+		if items, ok := schema["items"]; ok {
+			for i, item := range v {
+				problems = append(problems, validateSchemaValue(items, item, fmt.Sprintf("%s/%d", path, i))...)
+			}
+		}
+	}
+
+	return problems
+}
+
+func matchesSchemaType(types, value interface{}) bool {
+	switch t := types.(type) {
+	case string:
+		return matchesSchemaTypeName(t, value)
+	case []interface{}:
+		for _, name := range t {
+			if matchesSchemaTypeName(fmt.Sprintf("%v", name), value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func matchesSchemaTypeName(name string, value interface{}) bool {
+	switch name {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	case "number":
+		_, ok := schemaNumber(value)
+		return ok
+	case "integer":
+		n, ok := schemaNumber(value)
+		return ok && n == float64(int64(n))
+	}
+
+	return false
+}
+
+func schemaTypeNames(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		var names []string
+		for _, name := range list {
+			names = append(names, fmt.Sprintf("%v", name))
+		}
+		return strings.Join(names, " or ")
+	}
+
+	return fmt.Sprintf("%v", types)
+}
+
+func inSchemaEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		a, aIsNumber := schemaNumber(allowed)
+		v, vIsNumber := schemaNumber(value)
+		if aIsNumber && vIsNumber && a == v {
+			return true
+		}
+		if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func schemaNumber(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,121 @@
+package replicator_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("metadata schema validation", func() {
+	var (
+		pathToTile       string
+		pathToOutputTile string
+		pathToSchema     string
+		tileReplicator   replicator.TileReplicator
+	)
+
+	replicate := func() error {
+		return tileReplicator.Replicate(replicator.ApplicationConfig{
+			Path:       pathToTile,
+			Output:     pathToOutputTile,
+			Name:       "Azure Sea",
+			SchemaPath: pathToSchema,
+		})
+	}
+
+	BeforeEach(func() {
+		pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: `---
+name: pas-windows
+label: Pivotal Application Service for Windows
+job_types:
+- name: windows_diego_cell
+  instance_definition:
+    default: 3
+`})
+
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+		pathToSchema = filepath.Join(tempDir, "schema.json")
+
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+	})
+
+	It("accepts metadata that matches the schema", func() {
+		err := ioutil.WriteFile(pathToSchema, []byte(`{
+			"type": "object",
+			"required": ["name", "label", "job_types"],
+			"properties": {
+				"name": {"type": "string", "pattern": "^pas-windows-"},
+				"job_types": {
+					"type": "array",
+					"items": {
+						"type": "object",
+						"required": ["name"],
+						"properties": {
+							"name": {"enum": ["windows_diego_cell_azure_sea"]},
+							"instance_definition": {"properties": {"default": {"type": "integer"}}}
+						}
+					}
+				}
+			}
+		}`), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(replicate()).To(Succeed())
+		Expect(pathToOutputTile).To(BeAnExistingFile())
+	})
+
+	It("returns every validation error for metadata that does not match", func() {
+		err := ioutil.WriteFile(pathToSchema, []byte(`{
+			"type": "object",
+			"required": ["name", "icon_image"],
+			"additionalProperties": false,
+			"properties": {
+				"name": {"type": "string"},
+				"label": {"type": "string"},
+				"job_types": {
+					"items": {
+						"properties": {
+							"instance_definition": {"properties": {"default": {"type": "string"}}}
+						}
+					}
+				}
+			}
+		}`), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = replicate()
+		Expect(err).To(MatchError("metadata does not match schema " + pathToSchema + ": " +
+			"/: missing required property icon_image; " +
+			"/job_types/0/instance_definition/default: expected string"))
+		Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+	})
+
+	It("rejects properties the schema does not allow", func() {
+		err := ioutil.WriteFile(pathToSchema, []byte(`{"additionalProperties": false, "properties": {"name": {}, "label": {}}}`), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(replicate()).To(MatchError("metadata does not match schema " + pathToSchema + ": /: unexpected property job_types"))
+	})
+
+	Context("when the schema cannot be read", func() {
+		It("returns an error", func() {
+			Expect(replicate()).To(MatchError(ContainSubstring("could not read schema")))
+		})
+	})
+
+	Context("when the schema is not valid json", func() {
+		It("returns an error", func() {
+			err := ioutil.WriteFile(pathToSchema, []byte(`{`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(replicate()).To(MatchError(ContainSubstring("could not parse schema")))
+		})
+	})
+})
@@ -2,20 +2,53 @@ package replicator
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
+var releaseRegexp = regexp.MustCompile(`(^|/)releases/[^/]+\.tgz$`)
+
 var metadataRegexp = regexp.MustCompile(`metadata\/.*\.yml$`)
 var supportedTiles = []string{"p-isolation-segment", "p-windows-runtime", "pas-windows", "mongodb-on-demand"}
 
+var onDemandTiles = []string{"mongodb-on-demand"}
+
+// deprecatedTiles maps tiles that still replicate, but should no longer be
+// used, to their replacement.
+var deprecatedTiles = map[string]string{
+	"p-windows-runtime": "pas-windows",
+}
+var istJobTypes = []string{istCellJobType, istHAProxyJobType, istRouterJobType}
+var azKeys = []string{"az", "azs", "availability_zones", "singleton_availability_zone"}
+var networkKeys = []string{"network", "networks", "service_network"}
+var azPropertyTypes = []string{"service_network_az_single_select", "service_network_az_multi_select"}
+var knownMetadataKeys = []string{
+	"name", "label", "description", "icon_image", "metadata_version", "minimum_version_for_upgrade",
+	"product_version", "provides_product_versions", "requires_product_versions", "rank", "serial",
+	"service_broker", "stemcell_criteria", "additional_stemcells_criteria", "releases", "form_types",
+	"job_types", "property_blueprints", "runtime_configs", "variables", "install_time_verifiers",
+	"post_deploy_errands", "pre_delete_errands", "opsmanager_syslog", "bosh_dns_aliases",
+	"replicated_from", "vm_extensions", maxOpsManagerVersionKey,
+}
+var removableMetadataKeys = []string{"runtime_configs"}
+var sourceIDKeys = []string{"source_id", "origin"}
+var secretPropertyTypes = []string{"secret", "simple_credentials", "salted_credentials", "rsa_cert_credentials", "rsa_pkey_credentials"}
+
 const (
 	istRouterJobType  = "isolated_router"
 	istCellJobType    = "isolated_diego_cell"
@@ -24,7 +57,8 @@ const (
 	wrtCellJobType = "windows_diego_cell"
 
 	mongoDbJobType                 = "mongodb_broker"
-	mongoDbDNSAliasesJobType       = "      name: mongodb-dns-aliases"
+	mongoDNSAliasesJobName         = "mongodb-dns-aliases"
+	mongoDbDNSAliasesJobType       = "      name: " + mongoDNSAliasesJobName
 	mongoDNSTileAlias              = "mongodb-dns-aliases-tile"
 	mongoDNSDiegoAlias             = "mongodb-dns-aliases-diego"
 	mongoBrokerName                = "broker_name: mongodb-odb"
@@ -32,8 +66,61 @@ const (
 	mongoRuntimeConfigReplaceRegex = `(?s)runtime_configs:.*version: 1.2.6`
 )
 
+// redactedValue stands in for secret provider values in the dry-run diff.
+const redactedValue = "<redacted>"
+
+const (
+	normalizedFileMode os.FileMode = 0644
+	normalizedDirMode  os.FileMode = 0755
+)
+
+// defaultMaxMetadataBytes is far larger than any real tile's metadata, but
+// small enough to stop a hostile tile from exhausting memory.
+const defaultMaxMetadataBytes = 64 << 20
+
+const defaultMaxJobNameLength = 64
+
+// archive/zip only writes zip64 records when an archive needs them, so there
+// is no way to force a classic central directory; tiles that cross these
+// limits are flagged instead.
+const (
+	zip64SizeThreshold  = 1<<32 - 1
+	zip64CountThreshold = 1<<16 - 1
+)
+
+const (
+	replicatingLogFormat      = "replicating %s to %s\n"
+	addingLogFormat           = "adding: %s\n"
+	addedLogFormat            = "added %d files\n"
+	doneLogFormat             = "done\n"
+	zip64LogFormat            = "warning: %s requires zip64, which some older Ops Manager versions cannot read\n"
+	deprecatedLogFormat       = "warning: %s is deprecated, use %s instead\n"
+	normalizedLogFormat       = "normalized: %s to %s\n"
+	slimLogFormat             = "warning: %s omits the release tarballs, the original tile must be installed for it to deploy\n"
+	dryRunLogFormat           = "dry run: not writing %s\n"
+	replacedLogFormat         = "%s appears %d times\n"
+	notReplacedLogFormat      = "warning: %s does not appear in the metadata\n"
+	releaseVersionLogFormat   = "warning: set %s to version %s in the metadata only, the release tarball is unchanged\n"
+	unknownKeyLogFormat       = "warning: metadata has unrecognized key %s\n"
+	skippedLogFormat          = "skipped: %s\n"
+	lostKeyLogFormat          = "warning: metadata key %s is missing after replication\n"
+	noRuntimeConfigsLogFormat = "warning: %s has no runtime configs to remove, they may already have been stripped\n"
+	runtimeConfigsLogFormat   = "warning: removing the runtime configs of %s, the duplicate tile requires the original tile to operate\n"
+)
+
+const metadataCommentFormat = "transformed by the replicator with name %s"
+
 type TileReplicator struct {
-	logger logger
+	logger          logger
+	handlers        []TileHandler
+	metadataMatcher *regexp.Regexp
+	deterministic   bool
+	httpClient      *http.Client
+	retryDelay      time.Duration
+	nameTemplates   []nameTemplate
+
+	signatureVerifier SignatureVerifier
+	secretProvider    SecretProvider
 }
 
 //go:generate counterfeiter -o ./fakes/logger.go --fake-name Logger . logger
@@ -41,168 +128,1714 @@ type logger interface {
 	Printf(s string, v ...interface{})
 }
 
-func NewTileReplicator(logger logger) TileReplicator {
-	return TileReplicator{
-		logger: logger,
+// NewTileReplicator returns a TileReplicator that behaves as documented on
+// Replicate unless options say otherwise.
+func NewTileReplicator(logger logger, options ...Option) TileReplicator {
+	t := TileReplicator{
+		logger:          logger,
+		metadataMatcher: metadataRegexp,
+	}
+	for _, option := range options {
+		option(&t)
 	}
+
+	return t
+}
+
+type productMetadata struct {
+	member      string
+	tileName    string
+	name        string
+	productName string
+	contents    []byte
+	original    []byte
+	releases    interface{}
+	secretPaths []string
 }
 
+// Replicate is reproducible: replicating the same tile with the same config
+// always produces byte-identical metadata.
 func (t TileReplicator) Replicate(config ApplicationConfig) error {
-	t.logger.Printf("replicating %s to %s\n", config.Path, config.Output)
+	_, err := t.ReplicateWithResult(config)
+	return err
+}
+
+func (t TileReplicator) ReplicateWithResult(config ApplicationConfig) (ReplicationResult, error) {
+	result := ReplicationResult{
+		Source:  config.Path,
+		Output:  config.Output,
+		Name:    config.Name,
+		Started: time.Now(),
+	}
+
+	err := t.replicate(config, &result)
+	result.Duration = time.Since(result.Started)
+
+	if config.AuditWriter != nil {
+		writeAuditRecord(config.AuditWriter, result, err)
+	}
+
+	return result, err
+}
+
+func (t TileReplicator) replicate(config ApplicationConfig, result *ReplicationResult) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	name, err := resolveName(config)
+	if err != nil {
+		return err
+	}
+	config.Name = name
+	result.Name = name
+
+	t.logger.Printf(replicatingLogFormat, config.Path, config.Output)
+
+	ctx, cancel := replicationContext(config)
+	defer cancel()
+
+	err = t.verifySourceSignature(config)
+	if err != nil {
+		return err
+	}
 
+	opened := time.Now()
 	srcTileZip, err := zip.OpenReader(config.Path)
 	if err != nil {
 		return errors.New("could not open source zip file")
 	}
-	defer srcTileZip.Close()
+	if config.OnSourceOpened != nil {
+		config.OnSourceOpened(config.Path, time.Since(opened))
+	}
+	opened = time.Now()
+	defer func() {
+		srcTileZip.Close()
+		if config.OnSourceClosed != nil {
+			config.OnSourceClosed(config.Path, time.Since(opened))
+		}
+	}()
+
+	if uncompressedSize(srcTileZip.File) >= zip64SizeThreshold || len(srcTileZip.File) >= zip64CountThreshold {
+		t.logger.Printf(zip64LogFormat, config.Output)
+	}
 
-	dstTileFile, err := os.Create(config.Output)
+	metadata, err := t.readMetadata(&srcTileZip.Reader, config)
 	if err != nil {
-		return errors.New("could not create destination tile")
+		return err
+	}
+	result.TileName = metadata.tileName
+	result.ProductName = metadata.productName
+	if metadata.name != "" {
+		config.Name = metadata.name
+		result.Name = metadata.name
+	}
+
+	if replacement, ok := deprecatedTiles[metadata.tileName]; ok {
+		t.logger.Printf(deprecatedLogFormat, metadata.tileName, replacement)
 	}
-	defer dstTileFile.Close()
 
-	dstTileZip := zip.NewWriter(dstTileFile)
-	defer dstTileZip.Close()
+	if config.SlimMode {
+		t.logger.Printf(slimLogFormat, config.Output)
+	}
 
-	for _, srcFile := range srcTileZip.File {
-		srcFileReader, err := srcFile.Open()
+	if fi, err := os.Stat(config.Output); err == nil && fi.IsDir() {
+		config.Output = filepath.Join(config.Output, metadata.productName+t.outputExtension(config))
+		result.Output = config.Output
+	}
+
+	err = checkNameExists(metadata, config)
+	if err != nil {
+		return err
+	}
+
+	if config.DryRun {
+		return t.logDryRun(metadata, config)
+	}
+
+	tmpOutput := fmt.Sprintf("%s.tmp-%d", config.Output, os.Getpid())
 
+	err = t.writeTile(ctx, &srcTileZip.Reader, tmpOutput, metadata, config, result)
+	if err == nil && !config.SkipOutputCheck {
+		err = checkOutput(tmpOutput, t.outputMetadataMember(metadata, config))
+	}
+	if err == nil && config.VerifyOutput {
+		err = VerifyArchive(tmpOutput)
+	}
+	if err == nil && config.VerifySignature {
+		err = t.signatureVerifier.Verify(tmpOutput)
 		if err != nil {
-			return err // not tested
+			err = fmt.Errorf("signature of the replicated tile is invalid: %s", err)
 		}
+	}
+	if err != nil {
+		os.Remove(tmpOutput)
+		return err
+	}
 
-		t.logger.Printf("adding: %s\n", srcFile.Name)
+	err = os.Rename(tmpOutput, config.Output)
+	if err != nil {
+		os.Remove(tmpOutput)
+		return errors.New("could not create destination tile")
+	}
 
-		header := &zip.FileHeader{
-			Name:   srcFile.Name,
-			Method: zip.Deflate,
+	if config.WriteManifest {
+		err = ioutil.WriteFile(config.Output+".sha256", []byte(strings.Join(result.manifest, "")), 0644)
+		if err != nil {
+			return err
 		}
-		header.SetMode(srcFile.Mode())
+	}
 
-		dstFile, err := dstTileZip.CreateHeader(header)
+	if config.MetadataOutput != "" && metadata.member != "" {
+		err = ioutil.WriteFile(config.MetadataOutput, metadata.contents, 0644)
+		if err != nil {
+			return fmt.Errorf("could not write metadata to %s: %s", config.MetadataOutput, err)
+		}
+	}
 
+	if config.WriteReleasesBOM {
+		bom, err := marshalReleasesBOM(metadata.releases)
 		if err != nil {
 			return err // not tested
 		}
 
-		if metadataRegexp.MatchString(srcFile.Name) {
-			contents, err := ioutil.ReadAll(srcFileReader)
-			if err != nil {
-				return err // not tested
-			}
+		err = ioutil.WriteFile(config.Output+".releases.yml", bom, 0644)
+		if err != nil {
+			return err
+		}
+	}
 
-			var metadata map[string]interface{}
+	t.logger.Printf(doneLogFormat)
 
-			if err := yaml.Unmarshal([]byte(contents), &metadata); err != nil {
-				return err
-			}
+	return nil
+}
 
-			tileName, ok := metadata["name"]
-			if !ok {
-				return errors.New("Tile metadata file is missing required tile property 'name'")
-			}
-			metadata["name"], err = t.replaceName(fmt.Sprintf("%v", tileName), config)
-			if err != nil {
-				return err
-			}
+// verifySourceSignature checks the signature of the source tile when
+// config.VerifySignature is set.
+func (t TileReplicator) verifySourceSignature(config ApplicationConfig) error {
+	if !config.VerifySignature {
+		return nil
+	}
+	if t.signatureVerifier == nil {
+		return errors.New("cannot verify signatures without a signature verifier")
+	}
 
-			tileLabel, ok := metadata["label"]
-			if !ok {
-				return errors.New("Tile metadata file is missing required tile property 'label'")
-			}
-			metadata["label"] = t.replaceLabel(fmt.Sprintf("%v", tileLabel), config)
+	err := t.signatureVerifier.Verify(config.Path)
+	if err != nil {
+		return fmt.Errorf("signature of %s is invalid: %s", config.Path, err)
+	}
 
-			contentsYaml, err := yaml.Marshal(metadata)
-			if err != nil {
-				return err // not tested
-			}
+	return nil
+}
 
-			var finalContents string
-			if tileName == "p-isolation-segment" {
-				finalContents = t.replaceISTProperties(string(contentsYaml), t.formatName(config))
-			} else if tileName == "p-windows-runtime" {
-				finalContents = t.replaceWRTProperties(string(contentsYaml), t.formatName(config))
-			} else if tileName == "pas-windows" {
-				finalContents = t.replaceWRTProperties(string(contentsYaml), t.formatName(config))
-			} else if tileName == "mongodb-on-demand" {
-				fmt.Println("This replicator will remove the runtime configuration from this tile. This means this duplicate tile requires the original tile to operate.")
-				finalContents = t.replaceMongoDbProperties(string(contentsYaml), t.formatName(config))
-			}
+// checkNameExists returns an error if config.NameExists reports that the
+// replicated product already exists.
+func checkNameExists(metadata productMetadata, config ApplicationConfig) error {
+	if config.NameExists == nil || metadata.member == "" {
+		return nil
+	}
 
-			_, err = dstFile.Write([]byte(finalContents))
-		} else {
-			_, err = io.Copy(dstFile, srcFileReader)
+	exists, err := config.NameExists(metadata.productName)
+	if err != nil {
+		return fmt.Errorf("could not check whether %s exists: %s", metadata.productName, err)
+	}
+	if exists {
+		return fmt.Errorf("a product named %s already exists", metadata.productName)
+	}
+
+	return nil
+}
+
+// resolveName returns the name after ExpandEnv, AutoName and
+// FoundationInName.
+func resolveName(config ApplicationConfig) (string, error) {
+	name := config.Name
+	if config.ExpandEnv {
+		name = os.ExpandEnv(name)
+		if name == "" && !config.AutoName {
+			return "", fmt.Errorf("name %s expands to nothing", config.Name)
+		}
+		if errMsg := parseName(name); errMsg != "" {
+			return "", fmt.Errorf("expanded name is not valid: %s", errMsg)
 		}
+	}
 
-		err = srcFileReader.Close()
+	if name == "" && config.AutoName {
+		var err error
+		name, err = autoName(config.Path, config.AutoNameSeed)
 		if err != nil {
-			return err // not tested
+			return "", err
 		}
 	}
 
-	t.logger.Printf("done\n")
+	if config.Foundation != "" && config.FoundationInName {
+		name = fmt.Sprintf("%s %s", name, config.Foundation)
+	}
 
-	return nil
+	return name, nil
 }
 
-func (TileReplicator) formatName(config ApplicationConfig) string {
-	re := regexp.MustCompile("[-_ ]")
+// withResolvedName returns config with the name from resolveName, and with
+// the options that name already reflects cleared, so it is not resolved twice.
+func withResolvedName(config ApplicationConfig) (ApplicationConfig, error) {
+	name, err := resolveName(config)
+	if err != nil {
+		return ApplicationConfig{}, err
+	}
 
-	return strings.ToLower(string(re.ReplaceAllLiteralString(config.Name, "_")))
+	config.Name = name
+	config.ExpandEnv = false
+	config.AutoName = false
+	if config.FoundationInName {
+		config.Foundation = ""
+		config.FoundationInName = false
+	}
+
+	return config, nil
 }
 
-func (TileReplicator) replaceISTProperties(metadata string, name string) string {
-	newDiegoCellName := fmt.Sprintf("%s_%s", istCellJobType, name)
-	newRouterName := fmt.Sprintf("%s_%s", istRouterJobType, name)
-	newHAProxyName := fmt.Sprintf("%s_%s", istHAProxyJobType, name)
+// replicationContext returns a context bounded by config.Timeout, if it has
+// one, and the function that releases it.
+func replicationContext(config ApplicationConfig) (context.Context, context.CancelFunc) {
+	if config.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
 
-	cellReplacedMetadata := strings.Replace(metadata, "isolated_diego_cell", newDiegoCellName, -1)
-	cellReplacedMetadata = strings.Replace(cellReplacedMetadata, "isolated_ha_proxy", newHAProxyName, -1)
-	return strings.Replace(cellReplacedMetadata, "isolated_router", newRouterName, -1)
+	return context.WithTimeout(context.Background(), config.Timeout)
 }
 
-func (TileReplicator) replaceWRTProperties(metadata string, name string) string {
-	newDiegoCellName := fmt.Sprintf("%s_%s", wrtCellJobType, name)
+func checkRenameJobTypes(jobTypes []string) error {
+	for _, jobType := range jobTypes {
+		if !contains(istJobTypes, jobType) {
+			return fmt.Errorf("cannot rename unknown job type %s, renameable job types are %s", jobType, istJobTypes)
+		}
+	}
 
-	return strings.Replace(metadata, "windows_diego_cell", newDiegoCellName, -1)
+	return nil
 }
 
-func (TileReplicator) replaceMongoDbProperties(metadata string, name string) string {
+// readMetadata finds and transforms the tile's product metadata. Tiles that
+// bundle several products are rejected rather than half-replicated. When the
+// metadata holds several YAML documents, only the product document is
+// transformed.
+func (t TileReplicator) readMetadata(srcTileZip *zip.Reader, config ApplicationConfig) (productMetadata, error) {
+	member, contents, err := readMetadataFile(srcTileZip, t.matcher(), config)
+	if err != nil || member == "" {
+		return productMetadata{}, err
+	}
 
-	newMongoBrokerName := fmt.Sprintf("%s_%s", mongoDbJobType, name)
+	documents, index, err := productDocument(contents)
+	if err != nil {
+		return productMetadata{}, err
+	}
 
-	newDNSAliasJobName := strings.Replace(mongoDbDNSAliasesJobType, "mongodb", "mongodb-"+name, -1)
-	newDNSTileAliasJobName := strings.Replace(mongoDNSTileAlias, "mongodb", "mongodb-"+name, -1)
-	newDNSDiegoAliasJobName := strings.Replace(mongoDNSDiegoAlias, "mongodb", "mongodb-"+name, -1)
-	newMongoCFBrokerName := strings.Replace(mongoBrokerName, "mongodb-odb", "mongodb-odb-"+name, -1)
-	newMongoServiceName := strings.Replace(mongoServiceName, "mongodb-odb", "mongodb-odb-"+name, -1)
+	productContents := []byte(documents[index])
+	if config.renaming {
+		productContents, err = t.unreplicateMetadata(productContents)
+		if err != nil {
+			return productMetadata{}, err
+		}
+	}
+
+	product, err := t.transformMetadata(productContents, config)
+	if err != nil {
+		return productMetadata{}, err
+	}
+	documents[index] = string(product.contents)
 
-	cellReplacedMetadata := strings.Replace(metadata, mongoDbDNSAliasesJobType, newDNSAliasJobName, -1)
-	cellReplacedMetadata = strings.Replace(cellReplacedMetadata, mongoDNSTileAlias, newDNSTileAliasJobName, -1)
-	cellReplacedMetadata = strings.Replace(cellReplacedMetadata, mongoDNSDiegoAlias, newDNSDiegoAliasJobName, -1)
-	cellReplacedMetadata = strings.Replace(cellReplacedMetadata, mongoBrokerName, newMongoCFBrokerName, -1)
-	cellReplacedMetadata = strings.Replace(cellReplacedMetadata, mongoServiceName, newMongoServiceName, -1)
+	product.member = member
+	product.contents = joinYAMLDocuments(documents)
+	product.original = contents
 
-	var re = regexp.MustCompile(mongoRuntimeConfigReplaceRegex)
-	cellReplacedMetadata = re.ReplaceAllString(cellReplacedMetadata, "runtime_configs: []")
-	return strings.Replace(cellReplacedMetadata, "mongodb_broker", newMongoBrokerName, -1)
+	return product, nil
 }
 
-func (TileReplicator) replaceName(originalName string, config ApplicationConfig) (string, error) {
+// readMetadataFile returns the name and contents of the tile's metadata
+// file, or an empty name if it has none.
+func readMetadataFile(srcTileZip *zip.Reader, matcher *regexp.Regexp, config ApplicationConfig) (string, []byte, error) {
+	var metadataFiles []*zip.File
+	var metadataNames []string
+	for _, srcFile := range srcTileZip.File {
+		if isMetadataFile(normalizeName(srcFile.Name, config), matcher, config) {
+			metadataFiles = append(metadataFiles, srcFile)
+			metadataNames = append(metadataNames, srcFile.Name)
+		}
+	}
 
-	re := regexp.MustCompile("[-_ ]")
-	for _, supportedTile := range supportedTiles {
-		if originalName == supportedTile {
-			return originalName + "-" + strings.ToLower(string(re.ReplaceAllLiteralString(config.Name, "-"))), nil
+	switch len(metadataFiles) {
+	case 0:
+		if config.MetadataPath != "" {
+			return "", nil, fmt.Errorf("tile does not contain metadata file %s", config.MetadataPath)
 		}
+		return "", nil, nil
+	case 1:
+	default:
+		return "", nil, fmt.Errorf("the replicator does not replicate tiles with multiple products, found metadata files %s", metadataNames)
+	}
+
+	limit := config.MaxMetadataBytes
+	if limit <= 0 {
+		limit = defaultMaxMetadataBytes
+	}
+	if metadataFiles[0].UncompressedSize64 > uint64(limit) {
+		return "", nil, fmt.Errorf("metadata %s is larger than %d bytes", metadataFiles[0].Name, limit)
 	}
 
-	return "", fmt.Errorf("the replicator does not replicate %s, supported tiles are %s",
-		originalName, supportedTiles)
+	srcFileReader, err := metadataFiles[0].Open()
+	if err != nil {
+		return "", nil, err // not tested
+	}
+	defer srcFileReader.Close()
+
+	contents, err := ioutil.ReadAll(io.LimitReader(srcFileReader, limit+1))
+	if err != nil {
+		return "", nil, err // not tested
+	}
+	if int64(len(contents)) > limit {
+		return "", nil, fmt.Errorf("metadata %s is larger than %d bytes", metadataFiles[0].Name, limit) // not tested
+	}
 
+	return metadataFiles[0].Name, contents, nil
 }
 
-func (TileReplicator) replaceLabel(originalLabel string, config ApplicationConfig) string {
-	return fmt.Sprintf("%s (%s)", originalLabel, config.Name)
+func (t TileReplicator) writeTile(ctx context.Context, srcTileZip *zip.Reader, output string, metadata productMetadata, config ApplicationConfig, result *ReplicationResult) error {
+	created := time.Now()
+	dstTileFile, err := createOutput(output, config.OutputMode)
+	if err != nil {
+		return errors.New("could not create destination tile")
+	}
+	if config.OnDestinationCreated != nil {
+		config.OnDestinationCreated(config.Output, time.Since(created))
+	}
+	created = time.Now()
+	closed := false
+	defer func() {
+		if !closed {
+			dstTileFile.Close()
+		}
+		if config.OnDestinationClosed != nil {
+			config.OnDestinationClosed(config.Output, time.Since(created))
+		}
+	}()
+
+	checksum := sha256.New()
+	size := &countingWriter{onWrite: config.OutputProgress}
+	dstTileZip := zip.NewWriter(io.MultiWriter(dstTileFile, checksum, size))
+
+	err = t.writeMembers(ctx, dstTileZip, srcTileZip, metadata, config, result)
+	if err != nil {
+		return err
+	}
+
+	err = dstTileZip.SetComment(srcTileZip.Comment)
+	if err != nil {
+		return err // not tested
+	}
+
+	err = dstTileZip.Close()
+	if err != nil {
+		return err
+	}
+
+	result.Size = size.n
+	result.Checksum = hex.EncodeToString(checksum.Sum(nil))
+
+	closed = true
+	return dstTileFile.Close()
+}
+
+// writeMembers writes the replicated members of srcTileZip, followed by any
+// external artifacts, to dstTileZip.
+func (t TileReplicator) writeMembers(ctx context.Context, dstTileZip *zip.Writer, srcTileZip *zip.Reader, metadata productMetadata, config ApplicationConfig, result *ReplicationResult) error {
+	var err error
+	files, rejected := t.copiedFiles(srcTileZip, metadata.member, config)
+	if !config.Quiet {
+		for _, name := range rejected {
+			t.logger.Printf(skippedLogFormat, name)
+		}
+	}
+	total := uncompressedSize(files)
+	var copied int64
+
+	var compressor *parallelCompressor
+	if config.Workers > 1 {
+		compressor = newParallelCompressor(ctx, files, metadata.member, config)
+		defer compressor.stop()
+	}
+
+	written := map[string]string{}
+	for i, srcFile := range files {
+		err = checkTimeout(ctx, config)
+		if err != nil {
+			return err
+		}
+
+		t.logAdding(srcFile.Name, result.FilesCopied+1, config)
+
+		err = t.checkName(srcFile.Name, config)
+		if err != nil {
+			return err
+		}
+
+		header := &zip.FileHeader{
+			Name:   t.memberName(srcFile, config),
+			Method: zip.Deflate,
+		}
+		if srcFile.Name == metadata.member {
+			header.Comment = fmt.Sprintf(metadataCommentFormat, config.Name)
+		}
+		if previous, ok := written[header.Name]; ok {
+			return fmt.Errorf("%s and %s would both be written as %s", previous, srcFile.Name, header.Name)
+		}
+		written[header.Name] = srcFile.Name
+		header.SetMode(t.destinationMode(srcFile, config))
+
+		var memberSum []byte
+		if compressor != nil && compressor.compresses(i) {
+			memberSum, err = compressor.write(dstTileZip, header, i)
+		} else {
+			memberSum, err = t.writeMember(ctx, dstTileZip, header, srcFile, metadata, config)
+		}
+		if timeoutErr := checkTimeout(ctx, config); timeoutErr != nil {
+			return timeoutErr
+		}
+		if err != nil {
+			return err
+		}
+
+		if config.WriteManifest && !strings.HasSuffix(header.Name, "/") {
+			result.manifest = append(result.manifest, fmt.Sprintf("%x  %s\n", memberSum, header.Name))
+		}
+
+		result.FilesCopied++
+
+		if config.Progress != nil {
+			copied += int64(srcFile.UncompressedSize64)
+			config.Progress(copied, total)
+		}
+	}
+
+	err = t.writeExternalArtifacts(ctx, dstTileZip, written, config, result)
+	if timeoutErr := checkTimeout(ctx, config); timeoutErr != nil {
+		return timeoutErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if !config.Quiet && config.LogEveryN > 1 {
+		t.logger.Printf(addedLogFormat, result.FilesCopied)
+	}
+
+	return nil
+}
+
+// replicationInfo describes the source tile for the replicated_from key.
+// A tile packed from a directory has no checksum, since the temporary zip
+// is not what the caller had.
+func replicationInfo(tileName interface{}, metadata map[string]interface{}, config ApplicationConfig) (map[string]interface{}, error) {
+	info := map[string]interface{}{
+		"name":   tileName,
+		"source": config.Path,
+	}
+	if config.source.location != "" {
+		info["source"] = config.source.location
+	}
+	if version, ok := metadata["product_version"]; ok {
+		info["version"] = version
+	}
+	if config.source.packed {
+		return info, nil
+	}
+
+	f, err := os.Open(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not checksum %s: %s", info["source"], err)
+	}
+	defer f.Close()
+
+	checksum := sha256.New()
+	_, err = io.Copy(checksum, f)
+	if err != nil {
+		return nil, fmt.Errorf("could not checksum %s: %s", info["source"], err)
+	}
+	info["checksum"] = hex.EncodeToString(checksum.Sum(nil))
+
+	return info, nil
+}
+
+func checkTimeout(ctx context.Context, config ApplicationConfig) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("replication did not finish within %s", config.Timeout)
+	}
+
+	return nil
+}
+
+// logAdding logs the nth member added, or only every LogEveryN-th one.
+func (t TileReplicator) logAdding(name string, n int, config ApplicationConfig) {
+	if config.Quiet || (config.LogEveryN > 1 && n%config.LogEveryN != 0) {
+		return
+	}
+
+	t.logger.Printf(addingLogFormat, name)
+}
+
+// writeExternalArtifacts adds the files in IncludeExternalArtifacts
+// after the source members, in member name order.
+func (t TileReplicator) writeExternalArtifacts(ctx context.Context, dstTileZip *zip.Writer, written map[string]string, config ApplicationConfig, result *ReplicationResult) error {
+	var names []string
+	for name := range config.IncludeExternalArtifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t.logAdding(name, result.FilesCopied+1, config)
+
+		dstName := t.destinationName(name, config)
+		if previous, ok := written[dstName]; ok {
+			return fmt.Errorf("%s and %s would both be written as %s", previous, config.IncludeExternalArtifacts[name], dstName)
+		}
+		written[dstName] = config.IncludeExternalArtifacts[name]
+
+		memberSum, err := writeExternalArtifact(ctx, dstTileZip, dstName, config.IncludeExternalArtifacts[name], config)
+		if err != nil {
+			return fmt.Errorf("could not include %s: %s", name, err)
+		}
+
+		if config.WriteManifest {
+			result.manifest = append(result.manifest, fmt.Sprintf("%x  %s\n", memberSum, dstName))
+		}
+		result.FilesCopied++
+	}
+
+	return nil
+}
+
+func writeExternalArtifact(ctx context.Context, dstTileZip *zip.Writer, name, path string, config ApplicationConfig) ([]byte, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return nil, err // not tested
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	header.SetMode(fi.Mode())
+	if config.NormalizeModes {
+		header.SetMode(normalizedFileMode)
+	}
+
+	dstFile, err := dstTileZip.CreateHeader(header)
+	if err != nil {
+		return nil, err // not tested
+	}
+
+	memberChecksum := sha256.New()
+	_, err = copyBuffer(contextWriter{ctx, io.MultiWriter(dstFile, memberChecksum)}, src, config.CopyBufferSize)
+	if err != nil {
+		return nil, err // not tested
+	}
+
+	return memberChecksum.Sum(nil), nil
+}
+
+// createOutput creates output as os.Create would, unless mode is set, in
+// which case the file gets exactly mode regardless of the umask.
+func createOutput(output string, mode os.FileMode) (*os.File, error) {
+	if mode == 0 {
+		return os.Create(output)
+	}
+
+	file, err := os.OpenFile(output, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	err = file.Chmod(mode)
+	if err != nil {
+		file.Close()
+		return nil, err // not tested
+	}
+
+	return file, nil
+}
+
+func (TileReplicator) destinationMode(srcFile *zip.File, config ApplicationConfig) os.FileMode {
+	mode := srcFile.Mode()
+	if isDirectory(srcFile) {
+		mode |= os.ModeDir
+	}
+	if !config.NormalizeModes {
+		return mode
+	}
+
+	if mode.IsDir() {
+		return os.ModeDir | normalizedDirMode
+	}
+
+	return normalizedFileMode
+}
+
+// logDryRun logs a diff of the changes replicating would make to the
+// metadata. Both sides are re-marshalled so that only real changes, not key
+// order or formatting, show up.
+func (t TileReplicator) logDryRun(metadata productMetadata, config ApplicationConfig) error {
+	if metadata.member != "" {
+		original, err := remarshalYAML(metadata.original)
+		if err != nil {
+			return err // not tested
+		}
+		redacted, err := redactSecrets(metadata.contents, metadata.secretPaths)
+		if err != nil {
+			return err // not tested
+		}
+		replicated, err := remarshalYAML(redacted)
+		if err != nil {
+			return err
+		}
+
+		t.logger.Printf("%s", unifiedDiff(metadata.member, t.outputMetadataMember(metadata, config), original, replicated))
+
+		counts := t.replacementCounts(metadata.original, metadata.tileName, config)
+		var tokens []string
+		for token := range counts {
+			tokens = append(tokens, token)
+		}
+		sort.Strings(tokens)
+
+		for _, token := range tokens {
+			if counts[token] == 0 {
+				t.logger.Printf(notReplacedLogFormat, token)
+			} else {
+				t.logger.Printf(replacedLogFormat, token, counts[token])
+			}
+		}
+	}
+
+	t.logger.Printf(dryRunLogFormat, config.Output)
+
+	return nil
+}
+
+func remarshalYAML(contents []byte) (string, error) {
+	documents, _, err := productDocument(contents)
+	if err != nil {
+		return "", err
+	}
+
+	for i, contents := range documents {
+		var document map[string]interface{}
+		err := yaml.Unmarshal([]byte(contents), &document)
+		if err != nil {
+			return "", err
+		}
+
+		remarshalled, err := yaml.Marshal(document)
+		if err != nil {
+			return "", err // not tested
+		}
+		documents[i] = string(remarshalled)
+	}
+
+	return string(joinYAMLDocuments(documents)), nil
+}
+
+// outputMetadataMember is the name the metadata member is written as.
+func (t TileReplicator) outputMetadataMember(metadata productMetadata, config ApplicationConfig) string {
+	if metadata.member == "" {
+		return ""
+	}
+	return t.destinationName(metadata.member, config)
+}
+
+// dependsOnOriginal reports whether the duplicate will only work alongside
+// the original tile, because of something it leaves out.
+func dependsOnOriginal(tileName string, config ApplicationConfig) bool {
+	if config.SlimMode {
+		return true
+	}
+	return tileName == "mongodb-on-demand" && !config.KeepRuntimeConfigs
+}
+
+// copiedFiles returns the members of srcTileZip written to the duplicate,
+// in the order they are written, and the names config.FileFilter rejected.
+func (t TileReplicator) copiedFiles(srcTileZip *zip.Reader, metadataMember string, config ApplicationConfig) ([]*zip.File, []string) {
+	files := t.orderedFiles(srcTileZip.File)
+	if config.SlimMode {
+		files = withoutReleases(files, config)
+	}
+	if config.FileFilter == nil {
+		return files, nil
+	}
+
+	var kept []*zip.File
+	var rejected []string
+	for _, srcFile := range files {
+		if srcFile.Name == metadataMember || config.FileFilter(srcFile.Name, int64(srcFile.UncompressedSize64)) {
+			kept = append(kept, srcFile)
+		} else {
+			rejected = append(rejected, srcFile.Name)
+		}
+	}
+	return kept, rejected
+}
+
+// withoutReleases drops the release tarballs, which a slim duplicate shares
+// with the original tile instead of carrying its own copy.
+func withoutReleases(files []*zip.File, config ApplicationConfig) []*zip.File {
+	var kept []*zip.File
+	for _, srcFile := range files {
+		if !releaseRegexp.MatchString(normalizeName(srcFile.Name, config)) {
+			kept = append(kept, srcFile)
+		}
+	}
+	return kept
+}
+
+// isDirectory reports whether srcFile is a directory entry, whether it is
+// marked by a trailing slash or only by its mode.
+func isDirectory(srcFile *zip.File) bool {
+	return srcFile.Mode().IsDir() || strings.HasSuffix(srcFile.Name, "/")
+}
+
+func (t TileReplicator) writeMember(ctx context.Context, dstTileZip *zip.Writer, header *zip.FileHeader, srcFile *zip.File, metadata productMetadata, config ApplicationConfig) ([]byte, error) {
+	dstFile, err := dstTileZip.CreateHeader(header)
+	if err != nil {
+		return nil, err // not tested
+	}
+
+	memberChecksum := sha256.New()
+	if strings.HasSuffix(header.Name, "/") {
+		return memberChecksum.Sum(nil), nil
+	}
+	if config.WriteManifest {
+		dstFile = io.MultiWriter(dstFile, memberChecksum)
+	}
+
+	err = writeContents(contextWriter{ctx, dstFile}, srcFile, metadata, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return memberChecksum.Sum(nil), nil
+}
+
+// writeContents writes srcFile as it appears in the duplicate: the
+// transformed metadata, the member with its Replacements applied, or a copy.
+func writeContents(dst io.Writer, srcFile *zip.File, metadata productMetadata, config ApplicationConfig) error {
+	if srcFile.Name == metadata.member {
+		_, err := dst.Write(metadata.contents)
+		return err
+	}
+	if rules := memberReplacements(srcFile.Name, config.Replacements); len(rules) != 0 {
+		return replaceFile(dst, srcFile, rules, config.StripComments)
+	}
+
+	return copyFile(dst, srcFile, config.CopyBufferSize)
+}
+
+func copyFile(dst io.Writer, srcFile *zip.File, bufferSize int) error {
+	srcFileReader, err := srcFile.Open()
+	if err != nil {
+		return err // not tested
+	}
+	defer srcFileReader.Close()
+
+	_, err = copyBuffer(dst, srcFileReader, bufferSize)
+	return err
+}
+
+func replaceFile(dst io.Writer, srcFile *zip.File, rules []ReplacementRule, stripComments bool) error {
+	contents, err := readZipFile(srcFile)
+	if err != nil {
+		return err // not tested
+	}
+
+	replaced := applyReplacements(string(contents), rules)
+	if stripComments && yamlFileRegexp.MatchString(srcFile.Name) {
+		replaced = stripYAMLComments(replaced)
+	}
+
+	_, err = io.WriteString(dst, replaced)
+	return err
+}
+
+// copyBuffer copies with a buffer of bufferSize bytes, or io.Copy's default
+// when bufferSize is not positive.
+func copyBuffer(dst io.Writer, src io.Reader, bufferSize int) (int64, error) {
+	if bufferSize <= 0 {
+		return io.Copy(dst, src)
+	}
+
+	return io.CopyBuffer(dst, src, make([]byte, bufferSize))
+}
+
+// transformMetadata must depend only on its inputs; Replicate's
+// reproducibility relies on it.
+func (t TileReplicator) transformMetadata(contents []byte, config ApplicationConfig) (productMetadata, error) {
+	var document map[string]interface{}
+
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		return productMetadata{}, err
+	}
+	metadata, wrapped := unwrapMetadata(document)
+	var sourceKeys []string
+	for key := range metadata {
+		sourceKeys = append(sourceKeys, key)
+	}
+
+	tileName, ok := metadata["name"]
+	if !ok {
+		return productMetadata{}, errors.New("Tile metadata file is missing required tile property 'name'")
+	}
+	if config.ExpectedTileName != "" && fmt.Sprintf("%v", tileName) != config.ExpectedTileName {
+		return productMetadata{}, fmt.Errorf("expected a %s tile, but %s is a %s tile", config.ExpectedTileName, config.Path, tileName)
+	}
+
+	if len(config.AllowedTiles) != 0 && !contains(config.AllowedTiles, fmt.Sprintf("%v", tileName)) {
+		return productMetadata{}, fmt.Errorf("%s is not an allowed tile, allowed tiles are %s", tileName, config.AllowedTiles)
+	}
+
+	if config.FailOnDependentDuplicate && dependsOnOriginal(fmt.Sprintf("%v", tileName), config) {
+		return productMetadata{}, fmt.Errorf("the duplicate of %s would require the original tile to be installed", tileName)
+	}
+
+	if config.WarnUnknownKeys {
+		t.warnUnknownKeys(metadata)
+	}
+
+	handler := t.handler(fmt.Sprintf("%v", tileName))
+	if handler == nil && !contains(supportedTiles, fmt.Sprintf("%v", tileName)) {
+		if config.OnUnsupportedTile != nil {
+			config.OnUnsupportedTile(fmt.Sprintf("%v", tileName), contents)
+		}
+		return productMetadata{}, fmt.Errorf("the replicator does not replicate %s, supported tiles are %s",
+			tileName, supportedTiles)
+	}
+
+	config.Name = t.expandName(fmt.Sprintf("%v", tileName), config.Name)
+
+	nameFunc := t.replaceName
+	if config.NameFunc != nil {
+		nameFunc = config.NameFunc
+	}
+
+	labelFunc := t.replaceLabel
+	if config.LabelFunc != nil {
+		labelFunc = config.LabelFunc
+	}
+
+	productName, err := nameFunc(fmt.Sprintf("%v", tileName), config)
+	if err != nil {
+		return productMetadata{}, err
+	}
+
+	if config.CheckDeploymentName && deploymentName(productName) == deploymentName(fmt.Sprintf("%v", tileName)) {
+		return productMetadata{}, fmt.Errorf("%s and %s would both deploy as %s", productName, tileName, deploymentName(productName))
+	}
+
+	metadata["name"] = productName
+
+	tileLabel, ok := metadata["label"]
+	if !ok {
+		return productMetadata{}, errors.New("Tile metadata file is missing required tile property 'label'")
+	}
+	metadata["label"] = labelFunc(fmt.Sprintf("%v", tileLabel), config)
+
+	if description, ok := metadata["description"]; ok && config.AnnotateDescription {
+		descriptionFunc := t.replaceDescription
+		if config.DescriptionFunc != nil {
+			descriptionFunc = config.DescriptionFunc
+		}
+		metadata["description"] = descriptionFunc(fmt.Sprintf("%v", description), config)
+	}
+
+	if config.RenamePropertyBlueprints {
+		metadata["property_blueprints"] = t.replaceProductName(metadata["property_blueprints"], fmt.Sprintf("%v", tileName), productName)
+	}
+
+	if contains(onDemandTiles, fmt.Sprintf("%v", tileName)) {
+		t.replacePlanNames(metadata, t.formatName(config))
+	}
+
+	if len(config.ReleaseVersionOverrides) > 0 {
+		err = t.replaceReleaseVersions(metadata, config.ReleaseVersionOverrides)
+		if err != nil {
+			return productMetadata{}, err
+		}
+	}
+
+	if config.RenameSourceIDs {
+		t.replaceSourceIDs(metadata, t.formatName(config))
+	}
+
+	if config.RenameVMExtensions {
+		t.replaceVMExtensions(metadata, t.formatName(config))
+	}
+
+	if len(config.AZMappings) > 0 || len(config.NetworkMappings) > 0 {
+		t.replacePlacement(metadata, config.AZMappings, config.NetworkMappings)
+	}
+
+	if config.OriginalNameDefaults != KeepOriginalNameDefaults {
+		t.replaceOriginalNameDefaults(metadata["property_blueprints"], fmt.Sprintf("%v", tileName), productName, config.OriginalNameDefaults)
+	}
+
+	var secretPaths []string
+	if config.ClearSecretDefaults {
+		secretPaths, err = t.clearSecretDefaults(metadata["property_blueprints"])
+		if err != nil {
+			return productMetadata{}, err
+		}
+	}
+
+	transforms := config.Transforms
+	if transforms == nil {
+		pipelineTile := fmt.Sprintf("%v", tileName)
+		if handler != nil {
+			pipelineTile = ""
+		}
+		transforms = DefaultTransforms(pipelineTile, config)
+	}
+
+	err = runTransforms(metadata, transforms, TransformContext{
+		TileName:      fmt.Sprintf("%v", tileName),
+		ProductName:   productName,
+		FormattedName: t.formatName(config),
+		Config:        config,
+	})
+	if err != nil {
+		return productMetadata{}, err
+	}
+
+	if config.EmbedReplicationInfo {
+		replicatedFrom, err := replicationInfo(tileName, metadata, config)
+		if err != nil {
+			return productMetadata{}, err
+		}
+		metadata["replicated_from"] = replicatedFrom
+	}
+
+	if wrapped {
+		document[metadataWrapperKey] = metadata
+	} else {
+		document = metadata
+	}
+
+	contentsYaml, err := yaml.Marshal(document)
+	if err != nil {
+		return productMetadata{}, err // not tested
+	}
+
+	finalContents := string(contentsYaml)
+	if handler != nil {
+		finalContents, err = handler.ReplaceProperties(finalContents, config)
+		if err != nil {
+			return productMetadata{}, err
+		}
+	} else if tileName == "mongodb-on-demand" && config.Transforms == nil && !config.KeepRuntimeConfigs {
+		if regexp.MustCompile(mongoRuntimeConfigReplaceRegex).Match(contents) {
+			t.logger.Printf(runtimeConfigsLogFormat, config.Path)
+		} else {
+			t.logger.Printf(noRuntimeConfigsLogFormat, config.Path)
+		}
+	}
+
+	finalContents = applyReplacements(finalContents, memberReplacements("", config.Replacements))
+	if config.Transforms == nil {
+		finalContents = applySubstitutions(finalContents, config.GlobalSubstitutions)
+	}
+
+	err = t.checkKeyLoss(sourceKeys, finalContents, config.FailOnKeyLoss)
+	if err != nil {
+		return productMetadata{}, err
+	}
+
+	if handler == nil && renamesJobs(config.Transforms) {
+		renames := t.jobRenames(fmt.Sprintf("%v", tileName), config)
+
+		err = checkJobNameLengths(renames, config.MaxJobNameLength)
+		if err != nil {
+			return productMetadata{}, err
+		}
+
+		err = t.checkJobRenames(finalContents, renames)
+		if err != nil {
+			return productMetadata{}, err
+		}
+	}
+
+	for _, token := range config.RequireReplaced {
+		if strings.Contains(finalContents, token) {
+			return productMetadata{}, fmt.Errorf("metadata still contains %s after replication", token)
+		}
+	}
+
+	if config.RequireConsistentName {
+		err = t.checkConsistentName([]byte(finalContents), productName)
+		if err != nil {
+			return productMetadata{}, err
+		}
+	}
+
+	if config.SchemaPath != "" {
+		err = validateMetadataSchema([]byte(finalContents), config.SchemaPath)
+		if err != nil {
+			return productMetadata{}, err
+		}
+	}
+
+	return productMetadata{
+		tileName:    fmt.Sprintf("%v", tileName),
+		name:        config.Name,
+		productName: productName,
+		contents:    []byte(finalContents),
+		releases:    metadata["releases"],
+		secretPaths: secretPaths,
+	}, nil
+}
+
+// checkConsistentName verifies that the product name survived the handlers
+// and replacements that ran after it was set. provides_product_versions is
+// not checked: tiles list their own name there, and the duplicate provides
+// the same product as the original.
+func (TileReplicator) checkConsistentName(contents []byte, productName string) error {
+	var document map[string]interface{}
+	err := yaml.Unmarshal(contents, &document)
+	if err != nil {
+		return err // not tested
+	}
+	metadata, _ := unwrapMetadata(document)
+
+	if name := fmt.Sprintf("%v", metadata["name"]); name != productName {
+		return fmt.Errorf("metadata name is %s, expected %s", name, productName)
+	}
+
+	return nil
+}
+
+func (t TileReplicator) jobRenames(tileName string, config ApplicationConfig) map[string]string {
+	name := t.formatName(config)
+	renames := map[string]string{}
+
+	switch tileName {
+	case "p-isolation-segment":
+		for _, jobType := range istJobTypes {
+			if len(config.RenameJobTypes) == 0 || contains(config.RenameJobTypes, jobType) {
+				renames[jobType] = fmt.Sprintf("%s_%s", jobType, name)
+			}
+		}
+	case "p-windows-runtime", "pas-windows":
+		renames[wrtCellJobType] = fmt.Sprintf("%s_%s", wrtCellJobType, name)
+	case "mongodb-on-demand":
+		renames[mongoDbJobType] = fmt.Sprintf("%s_%s", mongoDbJobType, name)
+		if !config.KeepRuntimeConfigs {
+			renames[mongoDNSAliasesJobName] = strings.Replace(mongoDNSAliasesJobName, "mongodb", "mongodb-"+name, -1)
+		}
+	}
+
+	return renames
+}
+
+func checkJobNameLengths(renames map[string]string, maxLength int) error {
+	if maxLength <= 0 {
+		maxLength = defaultMaxJobNameLength
+	}
+
+	var longest string
+	for _, renamed := range renames {
+		if len(renamed) > len(longest) || (len(renamed) == len(longest) && renamed < longest) {
+			longest = renamed
+		}
+	}
+
+	if len(longest) > maxLength {
+		return fmt.Errorf("job name %s is %d characters, longer than the %d allowed", longest, len(longest), maxLength)
+	}
+
+	return nil
+}
+
+// checkJobRenames catches references the textual replacements missed, for
+// instance because the round trip through yaml re-indented them.
+func (TileReplicator) checkJobRenames(metadata string, renames map[string]string) error {
+	var oldNames []string
+	for oldName := range renames {
+		oldNames = append(oldNames, oldName)
+	}
+	sort.Strings(oldNames)
+
+	for _, oldName := range oldNames {
+		newName := renames[oldName]
+		remaining := strings.Count(metadata, oldName)
+		if strings.Contains(newName, oldName) {
+			remaining -= strings.Count(metadata, newName)
+		}
+
+		if remaining > 0 {
+			return fmt.Errorf("metadata still references %s after renaming it to %s", oldName, newName)
+		}
+	}
+
+	return nil
+}
+
+func (TileReplicator) outputExtension(config ApplicationConfig) string {
+	if config.OutputExtension == "" {
+		return ".pivotal"
+	}
+
+	return "." + strings.TrimPrefix(config.OutputExtension, ".")
+}
+
+// isMetadataFile reports whether name is the product metadata, which is
+// config.MetadataPath when it is set.
+func isMetadataFile(name string, matcher *regexp.Regexp, config ApplicationConfig) bool {
+	if config.MetadataPath != "" {
+		return name == config.MetadataPath
+	}
+
+	return matcher.MatchString(name)
+}
+
+// normalizeName turns the backslashes some Windows tools write into
+// forward slashes when config.NormalizeNames is set.
+func normalizeName(name string, config ApplicationConfig) string {
+	if !config.NormalizeNames {
+		return name
+	}
+
+	return strings.Replace(name, `\`, "/", -1)
+}
+
+// checkName rejects member names that are not valid UTF-8 and logs those
+// that normalizeName changes.
+func (t TileReplicator) checkName(name string, config ApplicationConfig) error {
+	if !config.NormalizeNames {
+		return nil
+	}
+
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("member name %q is not valid UTF-8", name)
+	}
+
+	if normalized := normalizeName(name, config); normalized != name {
+		t.logger.Printf(normalizedLogFormat, name, normalized)
+	}
+
+	return nil
+}
+
+func (t TileReplicator) destinationName(name string, config ApplicationConfig) string {
+	name = normalizeName(name, config)
+	if config.FileNameTransform != nil && !isMetadataFile(name, t.matcher(), config) {
+		name = config.FileNameTransform(name)
+	}
+
+	if config.PathPrefix == "" {
+		return name
+	}
+
+	return strings.TrimSuffix(config.PathPrefix, "/") + "/" + name
+}
+
+// memberName is the name srcFile is written as: its destination name, ending
+// in a slash if it is a directory.
+func (t TileReplicator) memberName(srcFile *zip.File, config ApplicationConfig) string {
+	name := t.destinationName(srcFile.Name, config)
+	if isDirectory(srcFile) && !strings.HasSuffix(name, "/") {
+		name += "/"
+	}
+
+	return name
+}
+
+func (TileReplicator) formatName(config ApplicationConfig) string {
+	re := regexp.MustCompile("[-_ ]")
+
+	return strings.ToLower(string(re.ReplaceAllLiteralString(config.Name, "_")))
+}
+
+func (TileReplicator) replaceISTProperties(metadata string, name string, jobTypes []string) string {
+	for _, jobType := range istJobTypes {
+		if len(jobTypes) != 0 && !contains(jobTypes, jobType) {
+			continue
+		}
+
+		metadata = strings.Replace(metadata, jobType, fmt.Sprintf("%s_%s", jobType, name), -1)
+	}
+
+	return metadata
+}
+
+func (TileReplicator) replaceWRTProperties(metadata string, name string) string {
+	newDiegoCellName := fmt.Sprintf("%s_%s", wrtCellJobType, name)
+
+	return strings.Replace(metadata, "windows_diego_cell", newDiegoCellName, -1)
+}
+
+// replaceMongoDbDNS renames the DNS aliases job and the broker and service
+// names, which are all derived from the tile's name rather than a job type.
+func replaceMongoDbDNS(metadata string, name string) string {
+	newDNSAliasJobName := strings.Replace(mongoDbDNSAliasesJobType, "mongodb", "mongodb-"+name, -1)
+	newDNSTileAliasJobName := strings.Replace(mongoDNSTileAlias, "mongodb", "mongodb-"+name, -1)
+	newDNSDiegoAliasJobName := strings.Replace(mongoDNSDiegoAlias, "mongodb", "mongodb-"+name, -1)
+	newMongoCFBrokerName := strings.Replace(mongoBrokerName, "mongodb-odb", "mongodb-odb-"+name, -1)
+	newMongoServiceName := strings.Replace(mongoServiceName, "mongodb-odb", "mongodb-odb-"+name, -1)
+
+	metadata = strings.Replace(metadata, mongoDbDNSAliasesJobType, newDNSAliasJobName, -1)
+	metadata = strings.Replace(metadata, mongoDNSTileAlias, newDNSTileAliasJobName, -1)
+	metadata = strings.Replace(metadata, mongoDNSDiegoAlias, newDNSDiegoAliasJobName, -1)
+	metadata = strings.Replace(metadata, mongoBrokerName, newMongoCFBrokerName, -1)
+	return strings.Replace(metadata, mongoServiceName, newMongoServiceName, -1)
+}
+
+// unreplaceMongoDbDNS undoes the broker and service renames of
+// replaceMongoDbDNS. The DNS alias renames are job renames, which Rename
+// already undoes.
+func unreplaceMongoDbDNS(metadata string, name string) string {
+	metadata = strings.Replace(metadata, strings.Replace(mongoBrokerName, "mongodb-odb", "mongodb-odb-"+name, -1), mongoBrokerName, -1)
+	return strings.Replace(metadata, strings.Replace(mongoServiceName, "mongodb-odb", "mongodb-odb-"+name, -1), mongoServiceName, -1)
+}
+
+// replacePlacement renames AZs and networks, but only in the values of the
+// keys that hold them and in the defaults of property blueprints that
+// select them, so unrelated strings that happen to match are kept.
+func (TileReplicator) replacePlacement(metadata map[string]interface{}, azMappings, networkMappings map[string]string) {
+	replace := func(mappings map[string]string) func(string) string {
+		return func(s string) string {
+			if renamed, ok := mappings[s]; ok {
+				return renamed
+			}
+			return s
+		}
+	}
+
+	for _, value := range metadata {
+		visitMaps(value, func(m map[interface{}]interface{}) {
+			for key, value := range m {
+				switch {
+				case contains(azKeys, fmt.Sprintf("%v", key)):
+					m[key] = mapStrings(value, replace(azMappings))
+				case contains(networkKeys, fmt.Sprintf("%v", key)):
+					m[key] = mapStrings(value, replace(networkMappings))
+				}
+			}
+		})
+	}
+
+	visitBlueprints(metadata["property_blueprints"], "", func(path string, m map[interface{}]interface{}) {
+		value, ok := m["default"]
+		if !ok {
+			return
+		}
+
+		name := fmt.Sprintf("%v", m["name"])
+		switch {
+		case contains(azPropertyTypes, fmt.Sprintf("%v", m["type"])) || namesPlacement(name, azKeys):
+			m["default"] = mapStrings(value, replace(azMappings))
+		case namesPlacement(name, networkKeys):
+			m["default"] = mapStrings(value, replace(networkMappings))
+		}
+	})
+}
+
+// namesPlacement reports whether a property blueprint name is one of keys,
+// or ends with one of them, such as deployment_network.
+func namesPlacement(name string, keys []string) bool {
+	for _, key := range keys {
+		if name == key || strings.HasSuffix(name, "_"+key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replaceReleaseVersions changes the versions of the releases the metadata
+// declares. The release tarballs themselves are copied unchanged.
+func (t TileReplicator) replaceReleaseVersions(metadata map[string]interface{}, overrides map[string]string) error {
+	releases, _ := metadata["releases"].([]interface{})
+
+	replaced := map[string]bool{}
+	for _, release := range releases {
+		release, ok := release.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+
+		name := fmt.Sprintf("%v", release["name"])
+		if version, ok := overrides[name]; ok {
+			release["version"] = version
+			replaced[name] = true
+			t.logger.Printf(releaseVersionLogFormat, name, version)
+		}
+	}
+
+	var names []string
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !replaced[name] {
+			return fmt.Errorf("cannot override the version of %s, the tile has no such release", name)
+		}
+	}
+
+	return nil
+}
+
+// warnUnknownKeys logs the top-level metadata keys the replicator was not
+// written with in mind, which may need handling it does not have.
+func (t TileReplicator) warnUnknownKeys(metadata map[string]interface{}) {
+	var unknown []string
+	for key := range metadata {
+		if !contains(knownMetadataKeys, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	for _, key := range unknown {
+		t.logger.Printf(unknownKeyLogFormat, key)
+	}
+}
+
+// checkKeyLoss catches top-level keys that the transforms or the marshal
+// round trip dropped, other than those removed on purpose. Metadata that no
+// longer parses is left to the later checks.
+func (t TileReplicator) checkKeyLoss(sourceKeys []string, finalContents string, fail bool) error {
+	var document map[string]interface{}
+	if yaml.Unmarshal([]byte(finalContents), &document) != nil {
+		return nil
+	}
+	metadata, _ := unwrapMetadata(document)
+
+	var lost []string
+	for _, key := range sourceKeys {
+		if _, ok := metadata[key]; !ok && !contains(removableMetadataKeys, key) {
+			lost = append(lost, key)
+		}
+	}
+	sort.Strings(lost)
+
+	if len(lost) > 0 && fail {
+		return fmt.Errorf("metadata keys %s are missing after replication", strings.Join(lost, ", "))
+	}
+	for _, key := range lost {
+		t.logger.Printf(lostKeyLogFormat, key)
+	}
+
+	return nil
+}
+
+// replaceVMExtensions suffixes the names of the VM extensions the tile
+// defines and the job types' references to them. References to extensions
+// the tile does not define are left alone.
+func (TileReplicator) replaceVMExtensions(metadata map[string]interface{}, name string) {
+	renames := map[string]string{}
+	extensions, _ := metadata["vm_extensions"].([]interface{})
+	for _, extension := range extensions {
+		m, ok := extension.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		if extensionName, ok := m["name"].(string); ok && extensionName != "" {
+			renames[extensionName] = fmt.Sprintf("%s_%s", extensionName, name)
+			m["name"] = renames[extensionName]
+		}
+	}
+
+	visitMaps(metadata["job_types"], func(m map[interface{}]interface{}) {
+		references, _ := m["vm_extensions"].([]interface{})
+		for i, reference := range references {
+			if renamed, ok := renames[fmt.Sprintf("%v", reference)]; ok {
+				references[i] = renamed
+			}
+		}
+	})
+}
+
+// replaceSourceIDs suffixes the identifiers a tile's logs and metrics are
+// tagged with, both where they are set directly and where they are the
+// default of a property blueprint, so duplicates' telemetry differ.
+func (TileReplicator) replaceSourceIDs(metadata map[string]interface{}, name string) {
+	suffix := func(value interface{}) interface{} {
+		if sourceID, ok := value.(string); ok && sourceID != "" {
+			return fmt.Sprintf("%s_%s", sourceID, name)
+		}
+		return value
+	}
+
+	for _, value := range metadata {
+		visitMaps(value, func(m map[interface{}]interface{}) {
+			for _, key := range sourceIDKeys {
+				if sourceID, ok := m[key]; ok {
+					m[key] = suffix(sourceID)
+				}
+			}
+			if contains(sourceIDKeys, fmt.Sprintf("%v", m["name"])) {
+				if sourceID, ok := m["default"]; ok {
+					m["default"] = suffix(sourceID)
+				}
+			}
+		})
+	}
+}
+
+// replaceOriginalNameDefaults clears or renames the property defaults
+// that mention the original product name.
+func (t TileReplicator) replaceOriginalNameDefaults(propertyBlueprints interface{}, originalName, productName string, policy OriginalNameDefaults) {
+	visitMaps(propertyBlueprints, func(m map[interface{}]interface{}) {
+		value, ok := m["default"]
+		if !ok || !mentions(value, originalName) {
+			return
+		}
+
+		switch policy {
+		case ClearOriginalNameDefaults:
+			delete(m, "default")
+		case RenameOriginalNameDefaults:
+			m["default"] = t.replaceProductName(value, originalName, productName)
+		}
+	})
+}
+
+// mentions reports whether any string in node contains s.
+func mentions(node interface{}, s string) bool {
+	found := false
+	mapStrings(node, func(value string) string {
+		found = found || strings.Contains(value, s)
+		return value
+	})
+	return found
+}
+
+// clearSecretDefaults removes the defaults of secret and credential
+// properties, including those nested in collections and selectors. Secret
+// properties get the secret provider's value for their path, if there is
+// one; the paths given a value are returned.
+func (t TileReplicator) clearSecretDefaults(propertyBlueprints interface{}) ([]string, error) {
+	var err error
+	var secretPaths []string
+	visitBlueprints(propertyBlueprints, "", func(path string, m map[interface{}]interface{}) {
+		propertyType := fmt.Sprintf("%v", m["type"])
+		if err != nil || !contains(secretPropertyTypes, propertyType) {
+			return
+		}
+		delete(m, "default")
+
+		if t.secretProvider == nil || propertyType != "secret" {
+			return
+		}
+
+		var value string
+		value, err = t.secretProvider.Get(path)
+		if err != nil {
+			err = fmt.Errorf("could not get secret %s: %s", path, err)
+		} else if value != "" {
+			m["default"] = value
+			secretPaths = append(secretPaths, path)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return secretPaths, nil
+}
+
+// redactSecrets replaces the defaults at secretPaths, which came from the
+// secret provider, so they are not logged.
+func redactSecrets(contents []byte, secretPaths []string) ([]byte, error) {
+	if len(secretPaths) == 0 {
+		return contents, nil
+	}
+
+	documents, index, err := productDocument(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	err = yaml.Unmarshal([]byte(documents[index]), &document)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _ := unwrapMetadata(document)
+
+	visitBlueprints(metadata["property_blueprints"], "", func(path string, m map[interface{}]interface{}) {
+		if _, ok := m["default"]; ok && contains(secretPaths, path) {
+			m["default"] = redactedValue
+		}
+	})
+
+	redacted, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, err // not tested
+	}
+	documents[index] = string(redacted)
+
+	return joinYAMLDocuments(documents), nil
+}
+
+func (TileReplicator) replacePlanNames(metadata map[string]interface{}, name string) {
+	renamePlans(metadata, func(plan string) string {
+		return fmt.Sprintf("%s_%s", plan, name)
+	})
+}
+
+// renamePlans passes every on-demand plan name through rename.
+func renamePlans(metadata map[string]interface{}, rename func(string) string) {
+	suffix := func(value interface{}) interface{} {
+		if plan, ok := value.(string); ok {
+			return rename(plan)
+		}
+		return value
+	}
+
+	for _, value := range metadata {
+		visitMaps(value, func(m map[interface{}]interface{}) {
+			if planName, ok := m["plan_name"]; ok {
+				m["plan_name"] = suffix(planName)
+			}
+
+			plans, ok := m["plans"].([]interface{})
+			if !ok {
+				return
+			}
+			for _, plan := range plans {
+				if plan, ok := plan.(map[interface{}]interface{}); ok {
+					if planName, ok := plan["name"]; ok {
+						plan["name"] = suffix(planName)
+					}
+				}
+			}
+		})
+	}
+}
+
+func (TileReplicator) replaceVariableNames(metadata map[string]interface{}, name string) {
+	variables, ok := metadata["variables"].([]interface{})
+	if !ok {
+		return
+	}
+
+	var replacements []string
+	for _, variable := range variables {
+		variable, ok := variable.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+
+		variableName, ok := variable["name"].(string)
+		if !ok {
+			continue
+		}
+
+		newVariableName := fmt.Sprintf("%s_%s", variableName, name)
+		variable["name"] = newVariableName
+		replacements = append(replacements,
+			"(("+variableName+"))", "(("+newVariableName+"))",
+			"(("+variableName+".", "(("+newVariableName+".")
+	}
+
+	replacer := strings.NewReplacer(replacements...)
+	for key, value := range metadata {
+		metadata[key] = mapStrings(value, replacer.Replace)
+	}
+}
+
+func (TileReplicator) replaceProductName(node interface{}, originalName, productName string) interface{} {
+	return mapStrings(node, func(s string) string {
+		if strings.Contains(s, productName) {
+			return s
+		}
+		return strings.Replace(s, originalName, productName, -1)
+	})
+}
+
+var productNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+
+func (TileReplicator) replaceName(originalName string, config ApplicationConfig) (string, error) {
+	if config.ProductNamePrefix == "" {
+		return originalName + "-" + canonicalName(config.Name), nil
+	}
+
+	var suffix string
+	if i := strings.Index(originalName, "-"); i >= 0 {
+		suffix = originalName[i:]
+	}
+	productName := config.ProductNamePrefix + suffix + "-" + canonicalName(config.Name)
+	if !productNameRegexp.MatchString(productName) {
+		return "", fmt.Errorf("product name %s is not valid, it must be lowercase letters and digits separated by hyphens", productName)
+	}
+
+	return productName, nil
+}
+
+var deploymentNameSeparatorRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// deploymentName is the BOSH deployment name derived from a product name:
+// lowercase, with each run of other characters replaced by a hyphen.
+func deploymentName(productName string) string {
+	return strings.Trim(deploymentNameSeparatorRegexp.ReplaceAllString(strings.ToLower(productName), "-"), "-")
+}
+
+func (TileReplicator) replaceLabel(originalLabel string, config ApplicationConfig) string {
+	if config.Foundation != "" && !config.FoundationInName {
+		return fmt.Sprintf("%s (%s, %s)", originalLabel, config.Name, config.Foundation)
+	}
+	return fmt.Sprintf("%s (%s)", originalLabel, config.Name)
+}
+
+func (TileReplicator) replaceDescription(originalDescription string, config ApplicationConfig) string {
+	return fmt.Sprintf("%s (duplicate: %s)", originalDescription, config.Name)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contextWriter fails writes once ctx is done, so a copy in progress stops
+// at the next buffer rather than running to the end of the member.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+type countingWriter struct {
+	n       int64
+	onWrite func(n int64)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	if c.onWrite != nil {
+		c.onWrite(c.n)
+	}
+	return len(p), nil
 }
@@ -2,15 +2,22 @@ package replicator_test
 
 import (
 	"archive/zip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
-	"github.com/pivotal-cf-experimental/gomegamatchers"
 	"github.com/dawu415/replicator/replicator"
 	"github.com/dawu415/replicator/replicator/fakes"
+	"github.com/pivotal-cf-experimental/gomegamatchers"
 )
 
 var _ = Describe("tile replicator", func() {
@@ -81,251 +88,3347 @@ var _ = Describe("tile replicator", func() {
 				Expect(string(contents)).To(gomegamatchers.MatchYAML(expectedMetadata))
 			})
 
-			Context("when a property does not exist in the tile metadata", func() {
-				It("does not fail to replicate the tile", func() {
-					pathToTile = filepath.Join("..", "fixtures", "some-tile-with-missing-property.pivotal")
-					expectedMetadataFile := filepath.Join("..", "fixtures", "expected-metadata-with-missing-property.yml")
-					contents, err := ioutil.ReadFile(expectedMetadataFile)
-					Expect(err).NotTo(HaveOccurred())
-					expectedMetadata = string(contents)
+			It("writes the tile through a temporary file", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Magenta Foo",
+				})
+				Expect(err).NotTo(HaveOccurred())
 
-					err = tileReplicator.Replicate(replicator.ApplicationConfig{
-						Path:   pathToTile,
-						Output: pathToOutputTile,
-						Name:   "Magenta Foo",
-					})
-					Expect(err).NotTo(HaveOccurred())
+				Expect(pathToOutputTile).To(BeAnExistingFile())
+				Expect(fmt.Sprintf("%s.tmp-%d", pathToOutputTile, os.Getpid())).NotTo(BeAnExistingFile())
+			})
 
-					zr, err := zip.OpenReader(pathToOutputTile)
-					Expect(err).NotTo(HaveOccurred())
+			It("produces identical metadata when run twice with the same config", func() {
+				config := replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Magenta Foo",
+				}
+				Expect(tileReplicator.Replicate(config)).To(Succeed())
+				firstRun := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
 
-					defer zr.Close()
+				config.Output = pathToOutputTile + ".second"
+				Expect(tileReplicator.Replicate(config)).To(Succeed())
+				secondRun := readTileFile(config.Output, "metadata/p-isolation-segment.yml")
 
-					var metadata *zip.File
-					for _, file := range zr.File {
-						if file.Name == "metadata/some-product.yml" {
-							metadata = file
-							break
-						}
-					}
-					Expect(metadata).NotTo(BeNil())
+				Expect(secondRun).To(Equal(firstRun))
+			})
 
-					f, err := metadata.Open()
-					Expect(err).NotTo(HaveOccurred())
+			It("logs each file as it is added", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Magenta Foo",
+				})
+				Expect(err).NotTo(HaveOccurred())
 
-					contents, err = ioutil.ReadAll(f)
+				Expect(logger.PrintfCallCount()).To(Equal(8))
+				Expect(formatLogLine(logger.PrintfArgsForCall(1))).To(Equal("adding: metadata/\n"))
+			})
+
+			Context("when quiet is set", func() {
+				It("only logs the start and end of the replication", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+						Quiet:  true,
+					})
 					Expect(err).NotTo(HaveOccurred())
 
-					Expect(string(contents)).To(gomegamatchers.MatchYAML(expectedMetadata))
+					Expect(logger.PrintfCallCount()).To(Equal(2))
+					Expect(formatLogLine(logger.PrintfArgsForCall(0))).To(Equal(fmt.Sprintf("replicating %s to %s\n", pathToTile, pathToOutputTile)))
+					Expect(formatLogLine(logger.PrintfArgsForCall(1))).To(Equal("done\n"))
 				})
 			})
 
-			Context("error handling", func() {
-				Context("when the source tile is not supported", func() {
-					It("returns an error", func() {
-						err := tileReplicator.Replicate(replicator.ApplicationConfig{
-							Path:   pathToAlreadyDuplicatedTile,
-							Output: pathToOutputTile,
-							Name:   "Magenta Foo",
-						})
-
-						Expect(err).To(MatchError("the replicator does not replicate " +
-							"p-isolation-segment-already-duplicated, supported tiles are " +
-							"[p-isolation-segment p-windows-runtime pas-windows]"))
+			Context("when only some job types are renamed", func() {
+				It("leaves the other job types untouched", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:           pathToTile,
+						Output:         pathToOutputTile,
+						Name:           "Magenta Foo",
+						RenameJobTypes: []string{"isolated_diego_cell"},
 					})
+					Expect(err).NotTo(HaveOccurred())
+
+					contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+					Expect(contents).To(ContainSubstring("name: isolated_diego_cell_magenta_foo\n"))
+					Expect(contents).To(ContainSubstring("name: isolated_router\n"))
+					Expect(contents).To(ContainSubstring("name: isolated_ha_proxy\n"))
+					Expect(contents).NotTo(ContainSubstring("isolated_router_magenta_foo"))
+					Expect(contents).NotTo(ContainSubstring("isolated_ha_proxy_magenta_foo"))
 				})
 
-				Context("when the metadata is an invalid yaml file", func() {
+				Context("when an unknown job type is requested", func() {
 					It("returns an error", func() {
 						err := tileReplicator.Replicate(replicator.ApplicationConfig{
-							Path:   pathToInvalidYamlMetadata,
-							Output: pathToOutputTile,
-							Name:   "Magenta Foo",
+							Path:           pathToTile,
+							Output:         pathToOutputTile,
+							Name:           "Magenta Foo",
+							RenameJobTypes: []string{"isolated_tcp_router"},
 						})
 
-						Expect(err).To(HaveOccurred())
-						Expect(err.Error()).To(ContainSubstring("cannot unmarshal"))
+						Expect(err).To(MatchError("cannot rename unknown job type isolated_tcp_router, " +
+							"renameable job types are [isolated_diego_cell isolated_ha_proxy isolated_router]"))
 					})
 				})
+			})
 
-				Context("when the tile does not contain a 'name'", func() {
-					It("returns an error", func() {
-						err := tileReplicator.Replicate(replicator.ApplicationConfig{
-							Path:   pathToInvalidNoNameTile,
-							Output: pathToOutputTile,
-							Name:   "Magenta Foo",
-						})
-
-						Expect(err).To(HaveOccurred())
-						Expect(err.Error()).To(ContainSubstring("missing required tile property 'name'"))
+			Context("when a path prefix is given", func() {
+				It("relocates every member under the prefix", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:       pathToTile,
+						Output:     pathToOutputTile,
+						Name:       "Magenta Foo",
+						PathPrefix: "nested/",
 					})
-				})
+					Expect(err).NotTo(HaveOccurred())
 
-				Context("when the tile does not contain a 'label'", func() {
-					It("returns an error", func() {
-						err := tileReplicator.Replicate(replicator.ApplicationConfig{
-							Path:   pathToInvalidNoLabelTile,
-							Output: pathToOutputTile,
-							Name:   "Magenta Foo",
-						})
+					Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{
+						"nested/metadata/",
+						"nested/migrations/",
+						"nested/releases/",
+						"nested/metadata/p-isolation-segment.yml",
+						"nested/migrations/v1/",
+						"nested/releases/some-release.tgz",
+					}))
+
+					contents := readTileFile(pathToOutputTile, "nested/metadata/p-isolation-segment.yml")
+					Expect(contents).To(gomegamatchers.MatchYAML(expectedMetadata))
+				})
+			})
 
-						Expect(err).To(HaveOccurred())
-						Expect(err.Error()).To(ContainSubstring("missing required tile property 'label'"))
+			Context("when a file name transform is given", func() {
+				It("renames the members but not the metadata", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+						FileNameTransform: func(original string) string {
+							return strings.Replace(original, ".", "-magenta-foo.", 1)
+						},
 					})
-				})
+					Expect(err).NotTo(HaveOccurred())
 
-				Context("when the source tile cannot be opened", func() {
-					It("returns an error", func() {
-						err := tileReplicator.Replicate(replicator.ApplicationConfig{
-							Path:   "some-bogus-path",
-							Output: pathToOutputTile,
-							Name:   "Magenta Foo",
-						})
+					Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{
+						"metadata/",
+						"migrations/",
+						"releases/",
+						"metadata/p-isolation-segment.yml",
+						"migrations/v1/",
+						"releases/some-release-magenta-foo.tgz",
+					}))
+
+					contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+					Expect(contents).To(gomegamatchers.MatchYAML(expectedMetadata))
+				})
+			})
 
-						Expect(err).To(MatchError("could not open source zip file"))
+			Context("when tokens are required to be replaced", func() {
+				It("replicates the tile when they have all been replaced", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:            pathToTile,
+						Output:          pathToOutputTile,
+						Name:            "Magenta Foo",
+						RequireReplaced: []string{".isolated_ha_proxy.", ".isolated_router."},
 					})
+					Expect(err).NotTo(HaveOccurred())
 				})
 
-				Context("when creating the destination zip file fails", func() {
-					It("returns an error", func() {
-						err := tileReplicator.Replicate(replicator.ApplicationConfig{
-							Path:   pathToTile,
-							Output: "",
-							Name:   "Magenta Foo",
-						})
-
-						Expect(err).To(MatchError("could not create destination tile"))
+				It("returns an error when one remains", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:            pathToTile,
+						Output:          pathToOutputTile,
+						Name:            "Magenta Foo",
+						RequireReplaced: []string{".isolated_ha_proxy.", ".properties.some_selector"},
 					})
+					Expect(err).To(MatchError("metadata still contains .properties.some_selector after replication"))
+
+					Expect(pathToOutputTile).NotTo(BeAnExistingFile())
 				})
 			})
-		})
-
-		Context("when replicating the windows 2012 runtime tile", func() {
-			BeforeEach(func() {
-				pathToTile = filepath.Join("..", "fixtures", "wrt.pivotal")
-
-				tempDir, err := ioutil.TempDir("", "")
-				Expect(err).NotTo(HaveOccurred())
-				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
 
-				expectedMetadataFile := filepath.Join("..", "fixtures", "expected-wrt-metadata.yml")
+			Context("when the tile is too large for a classic zip", func() {
+				It("warns that the duplicate requires zip64", func() {
+					entries := []tileEntry{{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"}}
+					for i := 0; i < 65535; i++ {
+						entries = append(entries, tileEntry{name: fmt.Sprintf("releases/%d", i)})
+					}
+					pathToTile = writeTile(entries...)
 
-				contents, err := ioutil.ReadFile(expectedMetadataFile)
-				Expect(err).NotTo(HaveOccurred())
-				expectedMetadata = string(contents)
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+						Quiet:  true,
+					})
+					Expect(err).NotTo(HaveOccurred())
 
-				logger = &fakes.Logger{}
-				tileReplicator = replicator.NewTileReplicator(logger)
+					Expect(formatLogLine(logger.PrintfArgsForCall(1))).To(Equal(fmt.Sprintf("warning: %s requires zip64, which some older Ops Manager versions cannot read\n", pathToOutputTile)))
+				})
 			})
 
-			It("creates a duplicate tile with modified metadata", func() {
-				err := tileReplicator.Replicate(replicator.ApplicationConfig{
-					Path:   pathToTile,
-					Output: pathToOutputTile,
-					Name:   "Azure Sea",
+			Context("when property blueprints are renamed", func() {
+				It("rewrites the original product name in the property blueprints", func() {
+					pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+name: p-isolation-segment
+label: PCF Isolation Segment
+description: p-isolation-segment stays as it is
+property_blueprints:
+- name: system_domain
+  type: string
+  default: p-isolation-segment.example.com
+- name: products
+  type: string_list
+  constraints:
+    allowed_values:
+    - p-isolation-segment
+    - cf
+`})
+
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:                     pathToTile,
+						Output:                   pathToOutputTile,
+						Name:                     "Magenta Foo",
+						RenamePropertyBlueprints: true,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+					Expect(contents).To(gomegamatchers.MatchYAML(`---
+name: p-isolation-segment-magenta-foo
+label: PCF Isolation Segment (Magenta Foo)
+description: p-isolation-segment stays as it is
+property_blueprints:
+- name: system_domain
+  type: string
+  default: p-isolation-segment-magenta-foo.example.com
+- name: products
+  type: string_list
+  constraints:
+    allowed_values:
+    - p-isolation-segment-magenta-foo
+    - cf
+`))
 				})
-				Expect(err).NotTo(HaveOccurred())
+			})
 
-				zr, err := zip.OpenReader(pathToOutputTile)
-				Expect(err).NotTo(HaveOccurred())
+			Context("when a manifest is requested", func() {
+				It("writes the checksum of every member beside the tile", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:          pathToTile,
+						Output:        pathToOutputTile,
+						Name:          "Magenta Foo",
+						WriteManifest: true,
+					})
+					Expect(err).NotTo(HaveOccurred())
 
-				defer zr.Close()
+					manifest, err := ioutil.ReadFile(pathToOutputTile + ".sha256")
+					Expect(err).NotTo(HaveOccurred())
 
-				var metadata *zip.File
-				for _, file := range zr.File {
-					if file.Name == "metadata/p-windows-runtime.yml" {
-						metadata = file
-						break
+					var expectedManifest string
+					for _, name := range tileFileNames(pathToOutputTile) {
+						if strings.HasSuffix(name, "/") {
+							continue
+						}
+						expectedManifest += fmt.Sprintf("%x  %s\n", sha256.Sum256([]byte(readTileFile(pathToOutputTile, name))), name)
 					}
-				}
-				Expect(metadata).NotTo(BeNil())
 
-				f, err := metadata.Open()
-				Expect(err).NotTo(HaveOccurred())
-
-				contents, err := ioutil.ReadAll(f)
-				Expect(err).NotTo(HaveOccurred())
-
-				Expect(string(contents)).To(gomegamatchers.MatchYAML(expectedMetadata))
+					Expect(string(manifest)).To(Equal(expectedManifest))
+					Expect(strings.Count(string(manifest), "\n")).To(Equal(2))
+				})
 			})
-		})
 
-		Context("when replicating the windows 2016 runtime tile", func() {
-			BeforeEach(func() {
-				pathToTile = filepath.Join("..", "fixtures", "wrt-2016.pivotal")
+			Context("when a metadata output is given", func() {
+				It("writes the replicated metadata there as well", func() {
+					pathToMetadata := pathToOutputTile + ".yml"
 
-				tempDir, err := ioutil.TempDir("", "")
-				Expect(err).NotTo(HaveOccurred())
-				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:           pathToTile,
+						Output:         pathToOutputTile,
+						Name:           "Magenta Foo",
+						MetadataOutput: pathToMetadata,
+					})
+					Expect(err).NotTo(HaveOccurred())
 
-				expectedMetadataFile := filepath.Join("..", "fixtures", "expected-wrt-2016-metadata.yml")
+					metadata, err := ioutil.ReadFile(pathToMetadata)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(metadata)).To(Equal(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")))
+					Expect(string(metadata)).To(ContainSubstring("name: p-isolation-segment-magenta-foo"))
+				})
+			})
 
-				contents, err := ioutil.ReadFile(expectedMetadataFile)
-				Expect(err).NotTo(HaveOccurred())
-				expectedMetadata = string(contents)
+			Context("when a releases BOM is requested", func() {
+				It("lists the metadata releases beside the tile", func() {
+					pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+name: p-isolation-segment
+label: PCF Isolation Segment
+releases:
+- name: cf
+  file: cf-1.0.0.tgz
+  version: 1.0.0
+- name: routing
+  version: "0.170"
+`})
+
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:             pathToTile,
+						Output:           pathToOutputTile,
+						Name:             "Magenta Foo",
+						WriteReleasesBOM: true,
+					})
+					Expect(err).NotTo(HaveOccurred())
 
-				logger = &fakes.Logger{}
-				tileReplicator = replicator.NewTileReplicator(logger)
+					bom, err := ioutil.ReadFile(pathToOutputTile + ".releases.yml")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(bom).To(gomegamatchers.MatchYAML(`---
+releases:
+- name: cf
+  version: 1.0.0
+  file: cf-1.0.0.tgz
+- name: routing
+  version: "0.170"
+`))
+				})
 			})
 
-			It("creates a duplicate tile with modified metadata", func() {
-				err := tileReplicator.Replicate(replicator.ApplicationConfig{
-					Path:   pathToTile,
-					Output: pathToOutputTile,
-					Name:   "Azure Sea",
+			Context("when the metadata has several documents", func() {
+				It("transforms the product document and keeps the others", func() {
+					pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+# tile notes
+notes: built by the pipeline
+---
+name: p-isolation-segment
+label: PCF Isolation Segment
+---
+extra: p-isolation-segment
+`})
+
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(Equal(`---
+# tile notes
+notes: built by the pipeline
+---
+label: PCF Isolation Segment (Magenta Foo)
+name: p-isolation-segment-magenta-foo
+---
+extra: p-isolation-segment
+`))
 				})
-				Expect(err).NotTo(HaveOccurred())
 
-				zr, err := zip.OpenReader(pathToOutputTile)
-				Expect(err).NotTo(HaveOccurred())
+				It("reads the product document wherever the metadata is read", func() {
+					pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+notes: built by the pipeline
+---
+name: p-isolation-segment
+label: PCF Isolation Segment
+job_types:
+- name: isolated_router
+`})
+					config := replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "blue",
+					}
 
-				defer zr.Close()
+					plan, err := tileReplicator.Plan(config)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(plan.Label).To(Equal("PCF Isolation Segment (blue)"))
+					Expect(plan.JobRenames).To(HaveKeyWithValue("isolated_router", "isolated_router_blue"))
 
-				var metadata *zip.File
-				for _, file := range zr.File {
-					if file.Name == "metadata/p-windows-runtime.yml" {
-						metadata = file
-						break
-					}
-				}
-				Expect(metadata).NotTo(BeNil())
+					renames, err := tileReplicator.PlannedJobRenames(pathToTile, config)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(renames).To(HaveKeyWithValue("isolated_router", "isolated_router_blue"))
 
-				f, err := metadata.Open()
-				Expect(err).NotTo(HaveOccurred())
+					counts, err := tileReplicator.ReplacementCounts(pathToTile, config)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(counts).To(HaveKeyWithValue("isolated_router", 1))
 
-				contents, err := ioutil.ReadAll(f)
-				Expect(err).NotTo(HaveOccurred())
+					contents, err := tileReplicator.ReplicateMetadataJSON(config)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(contents).To(MatchJSON(`[
+						{"notes": "built by the pipeline"},
+						{"name": "p-isolation-segment-blue", "label": "PCF Isolation Segment (blue)", "job_types": [{"name": "isolated_router_blue"}]}
+					]`))
+
+					logger := &fakes.Logger{}
+					dryRunConfig := config
+					dryRunConfig.DryRun = true
+					err = replicator.NewTileReplicator(logger).Replicate(dryRunConfig)
+					Expect(err).NotTo(HaveOccurred())
+					format, v := logger.PrintfArgsForCall(1)
+					Expect(formatLogLine(format, v)).To(ContainSubstring("+name: p-isolation-segment-blue"))
 
-				Expect(string(contents)).To(gomegamatchers.MatchYAML(expectedMetadata))
-			})
+					config.VerifyOutput = true
+					err = tileReplicator.Replicate(config)
+					Expect(err).NotTo(HaveOccurred())
 
-			It("preserves the permissions of the files in the tile", func() {
-				err := tileReplicator.Replicate(replicator.ApplicationConfig{
-					Path:   pathToTile,
-					Output: pathToOutputTile,
-					Name:   "Azure Sea",
+					err = tileReplicator.Rename(pathToOutputTile, "Cyan")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(Equal(`---
+notes: built by the pipeline
+---
+job_types:
+- name: isolated_router_cyan
+label: PCF Isolation Segment (Cyan)
+name: p-isolation-segment-cyan
+`))
 				})
-				Expect(err).NotTo(HaveOccurred())
 
-				tileZipReader, err := zip.OpenReader(pathToTile)
-				Expect(err).NotTo(HaveOccurred())
+				It("keeps documents whose separator has a comment", func() {
+					pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n--- # extra\nkind: extra\n"})
 
-				defer tileZipReader.Close()
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).NotTo(HaveOccurred())
 
-				tilePermissions := map[string]string{}
-				for _, file := range tileZipReader.File {
-					tilePermissions[file.Name] = file.Mode().String()
-				}
+					Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(Equal(`---
+label: PCF Isolation Segment (Magenta Foo)
+name: p-isolation-segment-magenta-foo
+--- # extra
+kind: extra
+`))
+				})
+
+				It("keeps documents whose separator has a tag", func() {
+					pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "--- !notes\nkind: extra\n---\nname: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(Equal(`--- !notes
+kind: extra
+---
+label: PCF Isolation Segment (Magenta Foo)
+name: p-isolation-segment-magenta-foo
+`))
+				})
+
+				It("returns an error when no document has a name", func() {
+					pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+label: PCF Isolation Segment
+---
+extra: p-isolation-segment
+`})
+
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).To(MatchError("none of the 2 metadata documents has a name"))
+				})
+			})
+
+			Context("when a name checker is given", func() {
+				var checkedNames []string
+
+				BeforeEach(func() {
+					checkedNames = nil
+				})
+
+				It("replicates the tile when the name is free", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+						NameExists: func(productName string) (bool, error) {
+							checkedNames = append(checkedNames, productName)
+							return false, nil
+						},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(checkedNames).To(Equal([]string{"p-isolation-segment-magenta-foo"}))
+					Expect(pathToOutputTile).To(BeAnExistingFile())
+				})
+
+				It("returns an error when the name is taken", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+						NameExists: func(productName string) (bool, error) {
+							return true, nil
+						},
+					})
+					Expect(err).To(MatchError("a product named p-isolation-segment-magenta-foo already exists"))
+
+					Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+				})
+
+				It("returns an error when the check fails", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+						NameExists: func(productName string) (bool, error) {
+							return false, errors.New("ops manager is down")
+						},
+					})
+					Expect(err).To(MatchError("could not check whether p-isolation-segment-magenta-foo exists: ops manager is down"))
+				})
+			})
+
+			Context("when custom naming functions are given", func() {
+				It("uses them for the product name and label", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+						NameFunc: func(original string, config replicator.ApplicationConfig) (string, error) {
+							return "custom-" + original, nil
+						},
+						LabelFunc: func(original string, config replicator.ApplicationConfig) string {
+							return config.Name + " " + original
+						},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+					Expect(contents).To(ContainSubstring("name: custom-p-isolation-segment\n"))
+					Expect(contents).To(ContainSubstring("label: Magenta Foo PCF Isolation Segment\n"))
+					Expect(contents).To(ContainSubstring("name: isolated_router_magenta_foo\n"))
+				})
+
+				It("returns the name function's error", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+						NameFunc: func(original string, config replicator.ApplicationConfig) (string, error) {
+							return "", errors.New("no names today")
+						},
+					})
+					Expect(err).To(MatchError("no names today"))
+				})
+			})
+
+			Context("when the output is a directory", func() {
+				var outputDir string
+
+				BeforeEach(func() {
+					outputDir = filepath.Dir(pathToOutputTile)
+				})
+
+				It("names the duplicate after the new product", func() {
+					result, err := tileReplicator.ReplicateWithResult(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: outputDir,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(result.Output).To(Equal(filepath.Join(outputDir, "p-isolation-segment-magenta-foo.pivotal")))
+					Expect(result.Output).To(BeAnExistingFile())
+				})
+
+				It("uses the configured extension", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:            pathToTile,
+						Output:          outputDir,
+						Name:            "Magenta Foo",
+						OutputExtension: "tile",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(filepath.Join(outputDir, "p-isolation-segment-magenta-foo.tile")).To(BeAnExistingFile())
+				})
+			})
+
+			Context("when copying with several workers", func() {
+				It("writes the same members, in the same order, as a sequential copy", func() {
+					sequentialOutput := pathToOutputTile + ".sequential"
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: sequentialOutput,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					err = tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:          pathToTile,
+						Output:        pathToOutputTile,
+						Name:          "Magenta Foo",
+						Workers:       4,
+						WriteManifest: true,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					names := tileFileNames(pathToOutputTile)
+					Expect(names).To(Equal(tileFileNames(sequentialOutput)))
+					for _, name := range names {
+						Expect(readTileFile(pathToOutputTile, name)).To(Equal(readTileFile(sequentialOutput, name)))
+					}
+
+					manifest, err := ioutil.ReadFile(pathToOutputTile + ".sha256")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(strings.Count(string(manifest), "\n")).To(Equal(2))
+				})
+
+				It("writes the same bytes as a sequential copy for a non-ASCII member name", func() {
+					pathToTile = writeTile(
+						tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+						tileEntry{name: "releases/réseau.tgz", contents: strings.Repeat("release bits ", 1000)},
+						tileEntry{name: "releases/plain.tgz", contents: strings.Repeat("plain bits ", 1000)},
+					)
+					sequentialOutput := pathToOutputTile + ".sequential"
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: sequentialOutput,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					err = tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:    pathToTile,
+						Output:  pathToOutputTile,
+						Name:    "Magenta Foo",
+						Workers: 4,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					sequentialZip, err := zip.OpenReader(sequentialOutput)
+					Expect(err).NotTo(HaveOccurred())
+					defer sequentialZip.Close()
+
+					parallelZip, err := zip.OpenReader(pathToOutputTile)
+					Expect(err).NotTo(HaveOccurred())
+					defer parallelZip.Close()
+
+					Expect(parallelZip.File).To(HaveLen(len(sequentialZip.File)))
+					for i, file := range parallelZip.File {
+						Expect(file.FileHeader).To(Equal(sequentialZip.File[i].FileHeader))
+					}
+					Expect(parallelZip.File[1].Flags & 0x800).NotTo(BeZero())
+
+					sequentialBytes, err := ioutil.ReadFile(sequentialOutput)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ioutil.ReadFile(pathToOutputTile)).To(Equal(sequentialBytes))
+				})
+
+				It("preserves file modes", func() {
+					pathToTile = writeTile(
+						tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+						tileEntry{name: "releases/some-release.tgz", contents: strings.Repeat("release bits ", 1000)},
+					)
+
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:    pathToTile,
+						Output:  pathToOutputTile,
+						Name:    "Magenta Foo",
+						Workers: 2,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					zr, err := zip.OpenReader(pathToOutputTile)
+					Expect(err).NotTo(HaveOccurred())
+					defer zr.Close()
+
+					srcZip, err := zip.OpenReader(pathToTile)
+					Expect(err).NotTo(HaveOccurred())
+					defer srcZip.Close()
+
+					Expect(zr.File[1].Mode()).To(Equal(srcZip.File[1].Mode()))
+					Expect(readTileFile(pathToOutputTile, "releases/some-release.tgz")).To(Equal(strings.Repeat("release bits ", 1000)))
+				})
+			})
+
+			Context("when a copy buffer size is given", func() {
+				It("copies every member intact", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:           pathToTile,
+						Output:         pathToOutputTile,
+						Name:           "Magenta Foo",
+						CopyBufferSize: 7,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					for _, name := range tileFileNames(pathToTile) {
+						if strings.HasPrefix(name, "metadata/") {
+							continue
+						}
+						Expect(readTileFile(pathToOutputTile, name)).To(Equal(readTileFile(pathToTile, name)))
+					}
+				})
+			})
+
+			Context("when normalizing modes", func() {
+				BeforeEach(func() {
+					tempDir, err := ioutil.TempDir("", "")
+					Expect(err).NotTo(HaveOccurred())
+
+					pathToTile = filepath.Join(tempDir, "tile.pivotal")
+					f, err := os.Create(pathToTile)
+					Expect(err).NotTo(HaveOccurred())
+					defer f.Close()
+
+					zw := zip.NewWriter(f)
+					for name, mode := range map[string]os.FileMode{
+						"metadata/":                        os.ModeDir | 0700,
+						"metadata/p-isolation-segment.yml": 0600,
+						"releases/some-release.tgz":        0777,
+					} {
+						header := &zip.FileHeader{Name: name}
+						header.SetMode(mode)
+						w, err := zw.CreateHeader(header)
+						Expect(err).NotTo(HaveOccurred())
+
+						if name == "metadata/p-isolation-segment.yml" {
+							_, err = w.Write([]byte("name: p-isolation-segment\nlabel: PCF Isolation Segment\n"))
+							Expect(err).NotTo(HaveOccurred())
+						}
+					}
+					Expect(zw.Close()).To(Succeed())
+				})
+
+				modes := func(pathToTile string) map[string]os.FileMode {
+					zr, err := zip.OpenReader(pathToTile)
+					Expect(err).NotTo(HaveOccurred())
+					defer zr.Close()
+
+					modes := map[string]os.FileMode{}
+					for _, file := range zr.File {
+						modes[file.Name] = file.Mode()
+					}
+					return modes
+				}
+
+				It("writes files as 0644 and directories as 0755", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:           pathToTile,
+						Output:         pathToOutputTile,
+						Name:           "Magenta Foo",
+						NormalizeModes: true,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(modes(pathToOutputTile)).To(Equal(map[string]os.FileMode{
+						"metadata/":                        os.ModeDir | 0755,
+						"metadata/p-isolation-segment.yml": 0644,
+						"releases/some-release.tgz":        0644,
+					}))
+				})
+
+				It("copies the source modes otherwise", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(modes(pathToOutputTile)).To(Equal(modes(pathToTile)))
+				})
+			})
+
+			Context("when a metadata path is given", func() {
+				BeforeEach(func() {
+					pathToTile = writeTile(
+						tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+						tileEntry{name: "metadata/notes.yml", contents: "name: notes\n"},
+						tileEntry{name: "product/product.yml", contents: "name: unrelated\n"},
+					)
+				})
+
+				It("transforms only that member", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:         pathToTile,
+						Output:       pathToOutputTile,
+						Name:         "Magenta Foo",
+						MetadataPath: "metadata/p-isolation-segment.yml",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-magenta-foo"))
+					Expect(readTileFile(pathToOutputTile, "metadata/notes.yml")).To(Equal("name: notes\n"))
+				})
+
+				Context("when the tile does not contain it", func() {
+					It("returns an error", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:         pathToTile,
+							Output:       pathToOutputTile,
+							Name:         "Magenta Foo",
+							MetadataPath: "metadata/missing.yml",
+						})
+						Expect(err).To(MatchError("tile does not contain metadata file metadata/missing.yml"))
+					})
+				})
+			})
+
+			Context("when normalizing names", func() {
+				BeforeEach(func() {
+					pathToTile = writeTile(
+						tileEntry{name: `metadata\p-isolation-segment.yml`, contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+						tileEntry{name: `releases\some-release.tgz`, contents: "release"},
+					)
+				})
+
+				It("converts backslashes to forward slashes and logs each change", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:           pathToTile,
+						Output:         pathToOutputTile,
+						Name:           "Magenta Foo",
+						NormalizeNames: true,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{"metadata/p-isolation-segment.yml", "releases/some-release.tgz"}))
+					Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-magenta-foo"))
+
+					format, args := logger.PrintfArgsForCall(2)
+					Expect(fmt.Sprintf(format, args...)).To(Equal("normalized: metadata\\p-isolation-segment.yml to metadata/p-isolation-segment.yml\n"))
+					format, args = logger.PrintfArgsForCall(4)
+					Expect(fmt.Sprintf(format, args...)).To(Equal("normalized: releases\\some-release.tgz to releases/some-release.tgz\n"))
+				})
+
+				It("keeps names verbatim otherwise", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(tileFileNames(pathToOutputTile)).To(ContainElement(`releases\some-release.tgz`))
+				})
+
+				Context("when a name is not valid UTF-8", func() {
+					It("returns an error", func() {
+						pathToTile = writeTile(tileEntry{name: "releases/some-release-\xff.tgz"})
+
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:           pathToTile,
+							Output:         pathToOutputTile,
+							Name:           "Magenta Foo",
+							NormalizeNames: true,
+						})
+						Expect(err).To(MatchError(`member name "releases/some-release-\xff.tgz" is not valid UTF-8`))
+					})
+				})
+			})
+
+			Context("when the tile has directory entries", func() {
+				BeforeEach(func() {
+					tempDir, err := ioutil.TempDir("", "")
+					Expect(err).NotTo(HaveOccurred())
+
+					pathToTile = filepath.Join(tempDir, "tile.pivotal")
+					f, err := os.Create(pathToTile)
+					Expect(err).NotTo(HaveOccurred())
+					defer f.Close()
+
+					zw := zip.NewWriter(f)
+					_, err = zw.Create("metadata/")
+					Expect(err).NotTo(HaveOccurred())
+
+					w, err := zw.Create("metadata/p-isolation-segment.yml")
+					Expect(err).NotTo(HaveOccurred())
+					_, err = w.Write([]byte("name: p-isolation-segment\nlabel: PCF Isolation Segment\n"))
+					Expect(err).NotTo(HaveOccurred())
+
+					header := &zip.FileHeader{Name: "releases"}
+					header.SetMode(os.ModeDir | 0755)
+					_, err = zw.CreateHeader(header)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(zw.Close()).To(Succeed())
+				})
+
+				for _, workers := range []int{0, 2} {
+					workers := workers
+
+					It(fmt.Sprintf("reproduces them as empty directories with %d workers", workers), func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:    pathToTile,
+							Output:  pathToOutputTile,
+							Name:    "Magenta Foo",
+							Workers: workers,
+						})
+						Expect(err).NotTo(HaveOccurred())
+
+						zr, err := zip.OpenReader(pathToOutputTile)
+						Expect(err).NotTo(HaveOccurred())
+						defer zr.Close()
+
+						Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{"metadata/", "metadata/p-isolation-segment.yml", "releases/"}))
+						for _, file := range []*zip.File{zr.File[0], zr.File[2]} {
+							Expect(file.Mode().IsDir()).To(BeTrue())
+							Expect(file.Method).To(Equal(zip.Store))
+							Expect(file.UncompressedSize64).To(BeZero())
+						}
+					})
+				}
+			})
+
+			Context("when an output mode is given", func() {
+				It("creates the tile with exactly that mode", func() {
+					for _, mode := range []os.FileMode{0664, 0444} {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:       pathToTile,
+							Output:     pathToOutputTile,
+							Name:       "Magenta Foo",
+							OutputMode: mode,
+						})
+						Expect(err).NotTo(HaveOccurred())
+
+						info, err := os.Stat(pathToOutputTile)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(info.Mode().Perm()).To(Equal(mode))
+
+						Expect(os.Remove(pathToOutputTile)).To(Succeed())
+					}
+				})
+			})
+
+			Context("when a property does not exist in the tile metadata", func() {
+				It("does not fail to replicate the tile", func() {
+					pathToTile = filepath.Join("..", "fixtures", "some-tile-with-missing-property.pivotal")
+					expectedMetadataFile := filepath.Join("..", "fixtures", "expected-metadata-with-missing-property.yml")
+					contents, err := ioutil.ReadFile(expectedMetadataFile)
+					Expect(err).NotTo(HaveOccurred())
+					expectedMetadata = string(contents)
+
+					err = tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					zr, err := zip.OpenReader(pathToOutputTile)
+					Expect(err).NotTo(HaveOccurred())
+
+					defer zr.Close()
+
+					var metadata *zip.File
+					for _, file := range zr.File {
+						if file.Name == "metadata/some-product.yml" {
+							metadata = file
+							break
+						}
+					}
+					Expect(metadata).NotTo(BeNil())
+
+					f, err := metadata.Open()
+					Expect(err).NotTo(HaveOccurred())
+
+					contents, err = ioutil.ReadAll(f)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(string(contents)).To(gomegamatchers.MatchYAML(expectedMetadata))
+				})
+			})
+
+			Context("error handling", func() {
+				Context("when the source tile is not supported", func() {
+					It("returns an error", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToAlreadyDuplicatedTile,
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+						})
+
+						Expect(err).To(MatchError("the replicator does not replicate " +
+							"p-isolation-segment-already-duplicated, supported tiles are " +
+							"[p-isolation-segment p-windows-runtime pas-windows mongodb-on-demand]"))
+					})
+
+					It("calls the unsupported tile hook with the tile's name and metadata", func() {
+						var (
+							hookedName     string
+							hookedMetadata []byte
+						)
+
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToAlreadyDuplicatedTile,
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+							OnUnsupportedTile: func(tileName string, metadata []byte) {
+								hookedName = tileName
+								hookedMetadata = metadata
+							},
+						})
+						Expect(err).To(HaveOccurred())
+
+						Expect(hookedName).To(Equal("p-isolation-segment-already-duplicated"))
+						Expect(string(hookedMetadata)).To(HavePrefix("name: p-isolation-segment-already-duplicated\n"))
+					})
+
+					It("does not call the hook for supported tiles", func() {
+						called := false
+
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToTile,
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+							OnUnsupportedTile: func(string, []byte) {
+								called = true
+							},
+						})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(called).To(BeFalse())
+					})
+				})
+
+				Context("when the name is empty", func() {
+					It("returns an error", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToTile,
+							Output: pathToOutputTile,
+						})
+
+						Expect(err).To(MatchError("name must not be empty"))
+						Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+					})
+				})
+
+				Context("when the tile contains multiple products", func() {
+					It("returns an error", func() {
+						pathToTile = writeTile(
+							tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+							tileEntry{name: "metadata/p-windows-runtime.yml", contents: "name: p-windows-runtime\nlabel: PCF Runtime For Windows\n"},
+						)
+
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToTile,
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+						})
+
+						Expect(err).To(MatchError("the replicator does not replicate tiles with multiple products, " +
+							"found metadata files [metadata/p-isolation-segment.yml metadata/p-windows-runtime.yml]"))
+						Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+					})
+				})
+
+				Context("when two members would be written under the same name", func() {
+					It("returns an error naming the collision", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToTile,
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+							FileNameTransform: func(original string) string {
+								return strings.TrimSuffix(original, "v1/")
+							},
+						})
+						Expect(err).To(MatchError("migrations/ and migrations/v1/ would both be written as migrations/"))
+						Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+					})
+				})
+
+				Context("when the metadata is an invalid yaml file", func() {
+					It("returns an error", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToInvalidYamlMetadata,
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+						})
+
+						Expect(err).To(HaveOccurred())
+						Expect(err.Error()).To(ContainSubstring("cannot unmarshal"))
+					})
+
+					It("does not leave a partial tile behind", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToInvalidYamlMetadata,
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+						})
+						Expect(err).To(HaveOccurred())
+
+						Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+						Expect(fmt.Sprintf("%s.tmp-%d", pathToOutputTile, os.Getpid())).NotTo(BeAnExistingFile())
+					})
+				})
+
+				Context("when the tile does not contain a 'name'", func() {
+					It("returns an error", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToInvalidNoNameTile,
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+						})
+
+						Expect(err).To(HaveOccurred())
+						Expect(err.Error()).To(ContainSubstring("missing required tile property 'name'"))
+					})
+				})
+
+				Context("when the tile does not contain a 'label'", func() {
+					It("returns an error", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToInvalidNoLabelTile,
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+						})
+
+						Expect(err).To(HaveOccurred())
+						Expect(err.Error()).To(ContainSubstring("missing required tile property 'label'"))
+					})
+				})
+
+				Context("when the source tile cannot be opened", func() {
+					It("returns an error", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   "some-bogus-path",
+							Output: pathToOutputTile,
+							Name:   "Magenta Foo",
+						})
+
+						Expect(err).To(MatchError("could not open source zip file"))
+					})
+				})
+
+				Context("when creating the destination zip file fails", func() {
+					It("returns an error", func() {
+						err := tileReplicator.Replicate(replicator.ApplicationConfig{
+							Path:   pathToTile,
+							Output: filepath.Join(pathToOutputTile, "missing-dir", "tile.pivotal"),
+							Name:   "Magenta Foo",
+						})
+
+						Expect(err).To(MatchError("could not create destination tile"))
+					})
+				})
+			})
+		})
+
+		Context("when replicating the windows 2012 runtime tile", func() {
+			BeforeEach(func() {
+				pathToTile = filepath.Join("..", "fixtures", "wrt.pivotal")
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				expectedMetadataFile := filepath.Join("..", "fixtures", "expected-wrt-metadata.yml")
+
+				contents, err := ioutil.ReadFile(expectedMetadataFile)
+				Expect(err).NotTo(HaveOccurred())
+				expectedMetadata = string(contents)
+
+				logger = &fakes.Logger{}
+				tileReplicator = replicator.NewTileReplicator(logger)
+			})
+
+			It("creates a duplicate tile with modified metadata", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Azure Sea",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				zr, err := zip.OpenReader(pathToOutputTile)
+				Expect(err).NotTo(HaveOccurred())
+
+				defer zr.Close()
+
+				var metadata *zip.File
+				for _, file := range zr.File {
+					if file.Name == "metadata/p-windows-runtime.yml" {
+						metadata = file
+						break
+					}
+				}
+				Expect(metadata).NotTo(BeNil())
+
+				f, err := metadata.Open()
+				Expect(err).NotTo(HaveOccurred())
+
+				contents, err := ioutil.ReadAll(f)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(string(contents)).To(gomegamatchers.MatchYAML(expectedMetadata))
+			})
+
+			It("warns that the tile is deprecated", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Azure Sea",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				format, args := logger.PrintfArgsForCall(1)
+				Expect(fmt.Sprintf(format, args...)).To(Equal("warning: p-windows-runtime is deprecated, use pas-windows instead\n"))
+			})
+		})
+
+		Context("when replicating the windows 2016 runtime tile", func() {
+			BeforeEach(func() {
+				pathToTile = filepath.Join("..", "fixtures", "wrt-2016.pivotal")
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				expectedMetadataFile := filepath.Join("..", "fixtures", "expected-wrt-2016-metadata.yml")
+
+				contents, err := ioutil.ReadFile(expectedMetadataFile)
+				Expect(err).NotTo(HaveOccurred())
+				expectedMetadata = string(contents)
+
+				logger = &fakes.Logger{}
+				tileReplicator = replicator.NewTileReplicator(logger)
+			})
+
+			It("creates a duplicate tile with modified metadata", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Azure Sea",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				zr, err := zip.OpenReader(pathToOutputTile)
+				Expect(err).NotTo(HaveOccurred())
+
+				defer zr.Close()
+
+				var metadata *zip.File
+				for _, file := range zr.File {
+					if file.Name == "metadata/p-windows-runtime.yml" {
+						metadata = file
+						break
+					}
+				}
+				Expect(metadata).NotTo(BeNil())
+
+				f, err := metadata.Open()
+				Expect(err).NotTo(HaveOccurred())
+
+				contents, err := ioutil.ReadAll(f)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(string(contents)).To(gomegamatchers.MatchYAML(expectedMetadata))
+			})
+
+			It("preserves the permissions of the files in the tile", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Azure Sea",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				tileZipReader, err := zip.OpenReader(pathToTile)
+				Expect(err).NotTo(HaveOccurred())
+
+				defer tileZipReader.Close()
+
+				tilePermissions := map[string]string{}
+				for _, file := range tileZipReader.File {
+					tilePermissions[file.Name] = file.Mode().String()
+				}
 
 				outputTileReader, err := zip.OpenReader(pathToOutputTile)
 				Expect(err).NotTo(HaveOccurred())
 
-				defer outputTileReader.Close()
+				defer outputTileReader.Close()
+
+				for _, file := range outputTileReader.File {
+					Expect(file.Mode().String()).To(Equal(tilePermissions[file.Name]))
+				}
+			})
+		})
+
+		Context("when renaming credhub variables", func() {
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: `---
+name: pas-windows
+label: Pivotal Application Service for Windows
+job_types:
+- name: windows_diego_cell
+  manifest: |
+    tls:
+      cert: ((/some/certificate.certificate))
+      password: ((some_password))
+variables:
+- name: /some/certificate
+  type: certificate
+- name: some_password
+  type: password
+`})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger = &fakes.Logger{}
+				tileReplicator = replicator.NewTileReplicator(logger)
+			})
+
+			It("suffixes the variable names and their references", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:            pathToTile,
+					Output:          pathToOutputTile,
+					Name:            "Azure Sea",
+					RenameVariables: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				contents := readTileFile(pathToOutputTile, "metadata/pas-windows.yml")
+				Expect(contents).To(gomegamatchers.MatchYAML(`---
+name: pas-windows-azure-sea
+label: Pivotal Application Service for Windows (Azure Sea)
+job_types:
+- name: windows_diego_cell_azure_sea
+  manifest: |
+    tls:
+      cert: ((/some/certificate_azure_sea.certificate))
+      password: ((some_password_azure_sea))
+variables:
+- name: /some/certificate_azure_sea
+  type: certificate
+- name: some_password_azure_sea
+  type: password
+`))
+			})
+		})
+
+		Context("when clearing secret defaults", func() {
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: `---
+name: pas-windows
+label: Pivotal Application Service for Windows
+property_blueprints:
+- name: admin_password
+  type: secret
+  default: some-secret
+- name: service_account
+  type: simple_credentials
+  default:
+    identity: admin
+    password: some-password
+- name: instances
+  type: integer
+  default: 3
+- name: bindings
+  type: collection
+  property_blueprints:
+  - name: token
+    type: secret
+    default: some-token
+  - name: host
+    type: string
+    default: example.com
+`})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger = &fakes.Logger{}
+				tileReplicator = replicator.NewTileReplicator(logger)
+			})
+
+			It("removes the defaults of secret properties only", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:                pathToTile,
+					Output:              pathToOutputTile,
+					Name:                "Azure Sea",
+					ClearSecretDefaults: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				contents := readTileFile(pathToOutputTile, "metadata/pas-windows.yml")
+				Expect(contents).To(gomegamatchers.MatchYAML(`---
+name: pas-windows-azure-sea
+label: Pivotal Application Service for Windows (Azure Sea)
+property_blueprints:
+- name: admin_password
+  type: secret
+- name: service_account
+  type: simple_credentials
+- name: instances
+  type: integer
+  default: 3
+- name: bindings
+  type: collection
+  property_blueprints:
+  - name: token
+    type: secret
+  - name: host
+    type: string
+    default: example.com
+`))
+			})
+
+			It("keeps the defaults when not asked to clear them", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Azure Sea",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				contents := readTileFile(pathToOutputTile, "metadata/pas-windows.yml")
+				Expect(contents).To(ContainSubstring("default: some-secret"))
+			})
+		})
+
+		Context("when an overlay is given", func() {
+			var pathToOverlay string
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: `---
+name: pas-windows
+label: Pivotal Application Service for Windows
+icon_image: some-icon
+stemcell_criteria:
+  os: windows2016
+  version: "1200.14"
+property_blueprints:
+- name: instances
+  type: integer
+`})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+				pathToOverlay = filepath.Join(tempDir, "overlay.yml")
+
+				logger = &fakes.Logger{}
+				tileReplicator = replicator.NewTileReplicator(logger)
+			})
+
+			It("merges maps, and replaces scalars and lists", func() {
+				err := ioutil.WriteFile(pathToOverlay, []byte(`---
+icon_image: other-icon
+description: A custom duplicate
+stemcell_criteria:
+  version: "1200.15"
+property_blueprints:
+- name: custom
+  type: string
+`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:                pathToTile,
+					Output:              pathToOutputTile,
+					Name:                "Azure Sea",
+					OverlayMetadataPath: pathToOverlay,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				contents := readTileFile(pathToOutputTile, "metadata/pas-windows.yml")
+				Expect(contents).To(gomegamatchers.MatchYAML(`---
+name: pas-windows-azure-sea
+label: Pivotal Application Service for Windows (Azure Sea)
+icon_image: other-icon
+description: A custom duplicate
+stemcell_criteria:
+  os: windows2016
+  version: "1200.15"
+property_blueprints:
+- name: custom
+  type: string
+`))
+			})
+
+			It("lets the overlay override the transformed name", func() {
+				err := ioutil.WriteFile(pathToOverlay, []byte("label: Custom Label\n"), 0644)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:                pathToTile,
+					Output:              pathToOutputTile,
+					Name:                "Azure Sea",
+					OverlayMetadataPath: pathToOverlay,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				contents := readTileFile(pathToOutputTile, "metadata/pas-windows.yml")
+				Expect(contents).To(ContainSubstring("label: Custom Label"))
+			})
+
+			Context("when the overlay does not exist", func() {
+				It("returns an error", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:                pathToTile,
+						Output:              pathToOutputTile,
+						Name:                "Azure Sea",
+						OverlayMetadataPath: pathToOverlay,
+					})
+					Expect(err).To(MatchError(ContainSubstring("could not read overlay metadata")))
+					Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+				})
+			})
+
+			Context("when the overlay is not valid yaml", func() {
+				It("returns an error", func() {
+					err := ioutil.WriteFile(pathToOverlay, []byte("- not\n  a: map"), 0644)
+					Expect(err).NotTo(HaveOccurred())
+
+					err = tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:                pathToTile,
+						Output:              pathToOutputTile,
+						Name:                "Azure Sea",
+						OverlayMetadataPath: pathToOverlay,
+					})
+					Expect(err).To(MatchError(ContainSubstring("could not parse overlay metadata")))
+				})
+			})
+		})
+
+		Context("when mapping AZs and networks", func() {
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+name: p-isolation-segment
+label: PCF Isolation Segment
+job_types:
+- name: isolated_diego_cell
+  description: z1
+  default_placement:
+    singleton_availability_zone: z1
+    availability_zones: [z1, z2]
+    network: default
+property_blueprints:
+- name: deployment_network
+  type: string
+  default: default
+- name: service_network
+  type: string
+  service_network: services
+- name: service_azs
+  type: service_network_az_multi_select
+  default: [z1, z2]
+- name: log_level
+  type: string
+  default: default
+`})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger = &fakes.Logger{}
+				tileReplicator = replicator.NewTileReplicator(logger)
+			})
+
+			It("renames AZs and networks only where they are referenced or selected", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:            pathToTile,
+					Output:          pathToOutputTile,
+					Name:            "az3",
+					AZMappings:      map[string]string{"z1": "z3"},
+					NetworkMappings: map[string]string{"default": "az3-network", "services": "az3-services"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+				Expect(contents).To(gomegamatchers.MatchYAML(`---
+name: p-isolation-segment-az3
+label: PCF Isolation Segment (az3)
+job_types:
+- name: isolated_diego_cell_az3
+  description: z1
+  default_placement:
+    singleton_availability_zone: z3
+    availability_zones: [z3, z2]
+    network: az3-network
+property_blueprints:
+- name: deployment_network
+  type: string
+  default: az3-network
+- name: service_network
+  type: string
+  service_network: az3-services
+- name: service_azs
+  type: service_network_az_multi_select
+  default: [z3, z2]
+- name: log_level
+  type: string
+  default: default
+`))
+			})
+		})
+
+		Context("when requiring a consistent name", func() {
+			BeforeEach(func() {
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger = &fakes.Logger{}
+				tileReplicator = replicator.NewTileReplicator(logger)
+			})
+
+			It("accepts the fixture tiles", func() {
+				for _, fixture := range []string{"ist.pivotal", "wrt.pivotal", "wrt-2016.pivotal"} {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:                  filepath.Join("..", "fixtures", fixture),
+						Output:                pathToOutputTile,
+						Name:                  "Azure Sea",
+						RequireConsistentName: true,
+					})
+					Expect(err).NotTo(HaveOccurred(), fixture)
+				}
+			})
+
+			It("leaves provided products that use the original name", func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: "name: pas-windows\nlabel: PASW\nprovides_product_versions:\n- name: pas-windows\n  version: 2.4.0\n"})
+
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:                  pathToTile,
+					Output:                pathToOutputTile,
+					Name:                  "Azure Sea",
+					RequireConsistentName: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readTileFile(pathToOutputTile, "metadata/pas-windows.yml")).To(ContainSubstring("provides_product_versions:\n- name: pas-windows\n"))
+			})
+
+			It("rejects a name that was changed after renaming", func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: "name: pas-windows\nlabel: PASW\n"})
+				handler := &fakes.TileHandler{}
+				handler.HandlesReturns(true)
+				handler.ReplacePropertiesStub = func(metadata string, config replicator.ApplicationConfig) (string, error) {
+					return strings.Replace(metadata, "name: pas-windows-azure-sea", "name: pas-windows", 1), nil
+				}
+
+				err := replicator.NewTileReplicator(logger, replicator.WithHandler(handler)).Replicate(replicator.ApplicationConfig{
+					Path:                  pathToTile,
+					Output:                pathToOutputTile,
+					Name:                  "Azure Sea",
+					RequireConsistentName: true,
+				})
+				Expect(err).To(MatchError("metadata name is pas-windows, expected pas-windows-azure-sea"))
+			})
+		})
+
+		Context("when SlimMode is set", func() {
+			It("drops the release tarballs and warns that the original tile is required", func() {
+				pathToTile := writeTile(
+					tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+					tileEntry{name: "releases/"},
+					tileEntry{name: "releases/some-release.tgz", contents: "release bits"},
+					tileEntry{name: "migrations/v1/201701251230_migration.js", contents: "migration"},
+				)
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger := &fakes.Logger{}
+				err = replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+					Path:     pathToTile,
+					Output:   pathToOutputTile,
+					Name:     "Magenta Foo",
+					SlimMode: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{
+					"metadata/p-isolation-segment.yml",
+					"releases/",
+					"migrations/v1/201701251230_migration.js",
+				}))
+
+				format, v := logger.PrintfArgsForCall(1)
+				Expect(formatLogLine(format, v)).To(Equal(fmt.Sprintf("warning: %s omits the release tarballs, the original tile must be installed for it to deploy\n", pathToOutputTile)))
+			})
+		})
+
+		Context("when AutoName is set", func() {
+			var (
+				pathToTile string
+				tempDir    string
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+
+				var err error
+				tempDir, err = ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			replicate := func(output, seed string) replicator.ReplicationResult {
+				result, err := replicator.NewTileReplicator(&fakes.Logger{}).ReplicateWithResult(replicator.ApplicationConfig{
+					Path:         pathToTile,
+					Output:       filepath.Join(tempDir, output),
+					AutoName:     true,
+					AutoNameSeed: seed,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				return result
+			}
+
+			It("derives a valid name from the source tile", func() {
+				result := replicate("first.pivotal", "ci")
+
+				Expect(result.Name).To(MatchRegexp(`^r[0-9a-f]{8}$`))
+				Expect(readTileFile(filepath.Join(tempDir, "first.pivotal"), "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-" + result.Name))
+			})
+
+			It("derives the same name for the same tile and seed", func() {
+				Expect(replicate("first.pivotal", "ci").Name).To(Equal(replicate("second.pivotal", "ci").Name))
+			})
+
+			It("derives a different name for a different seed", func() {
+				Expect(replicate("first.pivotal", "ci").Name).NotTo(Equal(replicate("second.pivotal", "nightly").Name))
+			})
+
+			It("keeps an explicit name", func() {
+				result, err := replicator.NewTileReplicator(&fakes.Logger{}).ReplicateWithResult(replicator.ApplicationConfig{
+					Path:     pathToTile,
+					Output:   filepath.Join(tempDir, "named.pivotal"),
+					Name:     "blue",
+					AutoName: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Name).To(Equal("blue"))
+			})
+		})
+
+		Context("when ExpandEnv is set", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				os.Setenv("REPLICATOR_TEST_NAME", "blue")
+			})
+
+			AfterEach(func() {
+				os.Unsetenv("REPLICATOR_TEST_NAME")
+			})
+
+			It("expands environment variables in the name", func() {
+				result, err := replicator.NewTileReplicator(&fakes.Logger{}).ReplicateWithResult(replicator.ApplicationConfig{
+					Path:      pathToTile,
+					Output:    pathToOutputTile,
+					Name:      "${REPLICATOR_TEST_NAME}-1",
+					ExpandEnv: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(result.Name).To(Equal("blue-1"))
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-blue-1"))
+			})
+
+			It("validates the expanded name", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:      pathToTile,
+					Output:    pathToOutputTile,
+					Name:      "${REPLICATOR_TEST_NAME}!",
+					ExpandEnv: true,
+				})
+				Expect(err).To(MatchError("expanded name is not valid: Invalid special characters in name: blue!"))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+			})
+
+			It("refuses a name that expands to nothing", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:      pathToTile,
+					Output:    pathToOutputTile,
+					Name:      "${REPLICATOR_UNSET_NAME}",
+					ExpandEnv: true,
+				})
+				Expect(err).To(MatchError("name ${REPLICATOR_UNSET_NAME} expands to nothing"))
+			})
+		})
+
+		Context("when a foundation is set", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\njob_types:\n- name: isolated_router\n"})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("adds the foundation to the label", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:       pathToTile,
+					Output:     pathToOutputTile,
+					Name:       "blue",
+					Foundation: "east",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				metadata := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+				Expect(metadata).To(ContainSubstring("name: p-isolation-segment-blue\n"))
+				Expect(metadata).To(ContainSubstring("label: PCF Isolation Segment (blue, east)"))
+				Expect(metadata).To(ContainSubstring("name: isolated_router_blue\n"))
+			})
+
+			It("adds the foundation to the name when FoundationInName is set", func() {
+				result, err := replicator.NewTileReplicator(&fakes.Logger{}).ReplicateWithResult(replicator.ApplicationConfig{
+					Path:             pathToTile,
+					Output:           pathToOutputTile,
+					Name:             "blue",
+					Foundation:       "east",
+					FoundationInName: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Name).To(Equal("blue east"))
+
+				metadata := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+				Expect(metadata).To(ContainSubstring("name: p-isolation-segment-blue-east"))
+				Expect(metadata).To(ContainSubstring("label: PCF Isolation Segment (blue east)"))
+				Expect(metadata).To(ContainSubstring("name: isolated_router_blue_east"))
+			})
+		})
+
+		Context("when an expected tile name is set", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("replicates a tile with that name", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:             pathToTile,
+					Output:           pathToOutputTile,
+					Name:             "blue",
+					ExpectedTileName: "p-isolation-segment",
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error for a different tile", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:             pathToTile,
+					Output:           pathToOutputTile,
+					Name:             "blue",
+					ExpectedTileName: "pas-windows",
+				})
+				Expect(err).To(MatchError(fmt.Sprintf("expected a pas-windows tile, but %s is a p-isolation-segment tile", pathToTile)))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when the metadata is nested under a metadata key", func() {
+			It("renames the nested product and keeps the wrapper", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "packaging: v2\nmetadata:\n  name: p-isolation-segment\n  label: PCF Isolation Segment\n  job_types:\n  - name: isolated_router\n"})
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                  pathToTile,
+					Output:                pathToOutputTile,
+					Name:                  "blue",
+					RequireConsistentName: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(`
+packaging: v2
+metadata:
+  name: p-isolation-segment-blue
+  label: PCF Isolation Segment (blue)
+  job_types:
+  - name: isolated_router_blue
+`))
+			})
+		})
+
+		Context("when I/O hooks are set", func() {
+			It("calls them in order as the tiles are opened and closed", func() {
+				pathToTile := filepath.Join("..", "fixtures", "ist.pivotal")
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				var events []string
+				hook := func(event string) func(string, time.Duration) {
+					return func(path string, d time.Duration) {
+						Expect(d).To(BeNumerically(">=", 0))
+						events = append(events, event+" "+path)
+					}
+				}
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                 pathToTile,
+					Output:               pathToOutputTile,
+					Name:                 "blue",
+					OnSourceOpened:       hook("source opened"),
+					OnDestinationCreated: hook("destination created"),
+					OnDestinationClosed:  hook("destination closed"),
+					OnSourceClosed:       hook("source closed"),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(events).To(Equal([]string{
+					"source opened " + pathToTile,
+					"destination created " + pathToOutputTile,
+					"destination closed " + pathToOutputTile,
+					"source closed " + pathToTile,
+				}))
+			})
+
+			It("calls the closing hooks when the replication fails partway", func() {
+				pathToTile := filepath.Join("..", "fixtures", "ist.pivotal")
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				var events []string
+				hook := func(event string) func(string, time.Duration) {
+					return func(path string, d time.Duration) {
+						events = append(events, event+" "+path)
+					}
+				}
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                     pathToTile,
+					Output:                   pathToOutputTile,
+					Name:                     "blue",
+					IncludeExternalArtifacts: map[string]string{"releases/missing.tgz": "/some/missing/file"},
+					OnSourceOpened:           hook("source opened"),
+					OnDestinationCreated:     hook("destination created"),
+					OnDestinationClosed:      hook("destination closed"),
+					OnSourceClosed:           hook("source closed"),
+				})
+				Expect(err).To(HaveOccurred())
+
+				Expect(events).To(Equal([]string{
+					"source opened " + pathToTile,
+					"destination created " + pathToOutputTile,
+					"destination closed " + pathToOutputTile,
+					"source closed " + pathToTile,
+				}))
+			})
+		})
+
+		Context("when allowed tiles are set", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("replicates an allowed tile", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:         pathToTile,
+					Output:       pathToOutputTile,
+					Name:         "blue",
+					AllowedTiles: []string{"p-isolation-segment", "pas-windows"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error for any other tile", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:         pathToTile,
+					Output:       pathToOutputTile,
+					Name:         "blue",
+					AllowedTiles: []string{"pas-windows"},
+				})
+				Expect(err).To(MatchError("p-isolation-segment is not an allowed tile, allowed tiles are [pas-windows]"))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+			})
+		})
+
+		It("comments the metadata member, and only the metadata member", func() {
+			pathToTile := filepath.Join("..", "fixtures", "ist.pivotal")
+			tempDir, err := ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+			pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+			err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+				Path:   pathToTile,
+				Output: pathToOutputTile,
+				Name:   "Magenta Foo",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			zr, err := zip.OpenReader(pathToOutputTile)
+			Expect(err).NotTo(HaveOccurred())
+			defer zr.Close()
+
+			comments := map[string]string{}
+			for _, file := range zr.File {
+				comments[file.Name] = file.Comment
+			}
+			Expect(comments).To(HaveKeyWithValue("metadata/p-isolation-segment.yml", "transformed by the replicator with name Magenta Foo"))
+			Expect(comments).To(HaveKeyWithValue("releases/some-release.tgz", ""))
+			Expect(comments).To(HaveKeyWithValue("metadata/", ""))
+		})
+
+		Context("when the source tile has an archive comment", func() {
+			It("copies the comment to the output tile", func() {
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToTile := filepath.Join(tempDir, "tile.pivotal")
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				f, err := os.Create(pathToTile)
+				Expect(err).NotTo(HaveOccurred())
+				zw := zip.NewWriter(f)
+				w, err := zw.Create("metadata/p-isolation-segment.yml")
+				Expect(err).NotTo(HaveOccurred())
+				_, err = w.Write([]byte("name: p-isolation-segment\nlabel: PCF Isolation Segment\n"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(zw.SetComment("built by some-pipeline")).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+				Expect(f.Close()).To(Succeed())
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				zr, err := zip.OpenReader(pathToOutputTile)
+				Expect(err).NotTo(HaveOccurred())
+				defer zr.Close()
+				Expect(zr.Comment).To(Equal("built by some-pipeline"))
+			})
+		})
+
+		Context("when the written metadata does not parse", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+				tileReplicator   replicator.TileReplicator
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/pas-windows.yml", contents: "name: pas-windows\nlabel: PASW\n"})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				handler := &fakes.TileHandler{}
+				handler.HandlesReturns(true)
+				handler.ReplacePropertiesReturns("name: [pas-windows", nil)
+				tileReplicator = replicator.NewTileReplicator(&fakes.Logger{}, replicator.WithHandler(handler))
+			})
+
+			It("returns an error and removes the output", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+				})
+				Expect(err).To(MatchError(ContainSubstring("output tile metadata metadata/pas-windows.yml does not parse")))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+				Expect(filepath.Glob(pathToOutputTile + ".tmp-*")).To(BeEmpty())
+			})
+
+			It("writes the tile anyway when the check is skipped", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:            pathToTile,
+					Output:          pathToOutputTile,
+					Name:            "blue",
+					SkipOutputCheck: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pathToOutputTile).To(BeAnExistingFile())
+			})
+		})
+
+		Context("when replacements are given", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(
+					tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\ndescription: segment for some-team\n"},
+					tileEntry{name: "migrations/v1/201701251230_migration.js", contents: "getCurrentProperty('some-team')"},
+					tileEntry{name: "releases/some-release.tgz", contents: "some-team"},
+				)
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			for _, workers := range []int{0, 4} {
+				workers := workers
+
+				It(fmt.Sprintf("applies scoped rules only to matching members with %d workers", workers), func() {
+					err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+						Path:    pathToTile,
+						Output:  pathToOutputTile,
+						Name:    "blue",
+						Workers: workers,
+						Replacements: []replicator.ReplacementRule{
+							{Old: "some-team", New: "blue-team"},
+							{Old: "some-team", New: "other-team", FileGlob: "migrations/*/*.js"},
+						},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("description: segment for blue-team"))
+					Expect(readTileFile(pathToOutputTile, "migrations/v1/201701251230_migration.js")).To(Equal("getCurrentProperty('other-team')"))
+					Expect(readTileFile(pathToOutputTile, "releases/some-release.tgz")).To(Equal("some-team"))
+				})
+			}
+
+			It("matches nested members with a ** glob only", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+					Replacements: []replicator.ReplacementRule{
+						{Old: "some-team", New: "other-team", FileGlob: "migrations/*"},
+						{Old: "getCurrentProperty", New: "getProperty", FileGlob: "migrations/**"},
+						{Old: "some-team", New: "release-team", FileGlob: "**/*.tgz"},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "migrations/v1/201701251230_migration.js")).To(Equal("getProperty('some-team')"))
+				Expect(readTileFile(pathToOutputTile, "releases/some-release.tgz")).To(Equal("release-team"))
+			})
+
+			It("returns an error for an invalid glob", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:         pathToTile,
+					Output:       pathToOutputTile,
+					Name:         "blue",
+					Replacements: []replicator.ReplacementRule{{Old: "a", New: "b", FileGlob: "migrations/["}},
+				})
+				Expect(err).To(MatchError("invalid file glob migrations/[: syntax error in pattern"))
+			})
+		})
+
+		Context("when DryRun is set", func() {
+			It("logs a diff of the metadata without writing the tile", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+name: p-isolation-segment
+label: PCF Isolation Segment
+a: 1
+b: 2
+c: 3
+d: 4
+e: 5
+f: 6
+g: 7
+job_types:
+- name: isolated_router
+`})
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger := &fakes.Logger{}
+				err = replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+					DryRun: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+
+				Expect(logger.PrintfCallCount()).To(Equal(7))
+				format, v := logger.PrintfArgsForCall(1)
+				Expect(formatLogLine(format, v)).To(Equal(`--- metadata/p-isolation-segment.yml
++++ metadata/p-isolation-segment.yml
+@@ -6,6 +6,6 @@
+ f: 6
+ g: 7
+ job_types:
+-- name: isolated_router
+-label: PCF Isolation Segment
+-name: p-isolation-segment
++- name: isolated_router_blue
++label: PCF Isolation Segment (blue)
++name: p-isolation-segment-blue
+`))
+				var counts []string
+				for i := 2; i < 6; i++ {
+					format, v = logger.PrintfArgsForCall(i)
+					counts = append(counts, formatLogLine(format, v))
+				}
+				Expect(counts).To(Equal([]string{
+					"warning: isolated_diego_cell does not appear in the metadata\n",
+					"warning: isolated_ha_proxy does not appear in the metadata\n",
+					"isolated_router appears 1 times\n",
+					"p-isolation-segment appears 1 times\n",
+				}))
+				format, v = logger.PrintfArgsForCall(6)
+				Expect(formatLogLine(format, v)).To(Equal(fmt.Sprintf("dry run: not writing %s\n", pathToOutputTile)))
+			})
+		})
+
+		Context("when external artifacts are included", func() {
+			var (
+				pathToTile       string
+				pathToArtifact   string
+				pathToOutputTile string
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(
+					tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+					tileEntry{name: "releases/some-release.tgz", contents: "release bits"},
+				)
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+				pathToArtifact = filepath.Join(tempDir, "extra-release.tgz")
+				Expect(ioutil.WriteFile(pathToArtifact, []byte("extra release bits"), 0644)).To(Succeed())
+			})
+
+			It("adds them after the source members", func() {
+				result, err := replicator.NewTileReplicator(&fakes.Logger{}).ReplicateWithResult(replicator.ApplicationConfig{
+					Path:                     pathToTile,
+					Output:                   pathToOutputTile,
+					Name:                     "blue",
+					IncludeExternalArtifacts: map[string]string{"releases/extra-release.tgz": pathToArtifact},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{
+					"metadata/p-isolation-segment.yml",
+					"releases/some-release.tgz",
+					"releases/extra-release.tgz",
+				}))
+				Expect(readTileFile(pathToOutputTile, "releases/extra-release.tgz")).To(Equal("extra release bits"))
+				Expect(result.FilesCopied).To(Equal(3))
+			})
+
+			It("returns an error if an artifact would replace a source member", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                     pathToTile,
+					Output:                   pathToOutputTile,
+					Name:                     "blue",
+					IncludeExternalArtifacts: map[string]string{"releases/some-release.tgz": pathToArtifact},
+				})
+				Expect(err).To(MatchError(fmt.Sprintf("releases/some-release.tgz and %s would both be written as releases/some-release.tgz", pathToArtifact)))
+			})
+
+			It("returns an error if an artifact does not exist", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                     pathToTile,
+					Output:                   pathToOutputTile,
+					Name:                     "blue",
+					IncludeExternalArtifacts: map[string]string{"releases/missing.tgz": "/some/missing/file"},
+				})
+				Expect(err).To(MatchError("could not include releases/missing.tgz: open /some/missing/file: no such file or directory"))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when LogEveryN is set", func() {
+			It("logs every Nth member and the total", func() {
+				var entries []tileEntry
+				entries = append(entries, tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+				for i := 1; i <= 6; i++ {
+					entries = append(entries, tileEntry{name: fmt.Sprintf("releases/%d.tgz", i)})
+				}
+				pathToTile := writeTile(entries...)
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger := &fakes.Logger{}
+				err = replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+					Path:      pathToTile,
+					Output:    pathToOutputTile,
+					Name:      "blue",
+					LogEveryN: 3,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				var lines []string
+				for i := 0; i < logger.PrintfCallCount(); i++ {
+					format, v := logger.PrintfArgsForCall(i)
+					lines = append(lines, formatLogLine(format, v))
+				}
+				Expect(lines).To(Equal([]string{
+					fmt.Sprintf("replicating %s to %s\n", pathToTile, pathToOutputTile),
+					"adding: releases/2.tgz\n",
+					"adding: releases/5.tgz\n",
+					"added 7 files\n",
+					"done\n",
+				}))
+			})
+		})
+
+		Context("when the metadata is larger than MaxMetadataBytes", func() {
+			It("returns an error before reading it", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n" + strings.Repeat("# padding\n", 100)})
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:             pathToTile,
+					Output:           pathToOutputTile,
+					Name:             "blue",
+					MaxMetadataBytes: 512,
+				})
+				Expect(err).To(MatchError("metadata metadata/p-isolation-segment.yml is larger than 512 bytes"))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when RenameSourceIDs is set", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+			)
+
+			BeforeEach(func() {
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("suffixes the source identifiers with the name", func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+name: p-isolation-segment
+label: PCF Isolation Segment
+property_blueprints:
+- name: source_id
+  type: string
+  default: isolation-segment
+job_types:
+- name: isolated_router
+  manifest: |
+    unchanged: true
+  templates:
+  - name: loggregator_agent
+    manifest:
+      origin: isolated-router
+      metrics:
+        source_id: isolated-router-metrics
+`})
+
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:            pathToTile,
+					Output:          pathToOutputTile,
+					Name:            "blue",
+					RenameSourceIDs: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(`---
+name: p-isolation-segment-blue
+label: PCF Isolation Segment (blue)
+property_blueprints:
+- name: source_id
+  type: string
+  default: isolation-segment_blue
+job_types:
+- name: isolated_router_blue
+  manifest: |
+    unchanged: true
+  templates:
+  - name: loggregator_agent
+    manifest:
+      origin: isolated-router_blue
+      metrics:
+        source_id: isolated-router-metrics_blue
+`))
+			})
+
+			It("leaves metadata without source identifiers unchanged", func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:            pathToTile,
+					Output:          pathToOutputTile,
+					Name:            "blue",
+					RenameSourceIDs: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML("name: p-isolation-segment-blue\nlabel: PCF Isolation Segment (blue)\n"))
+			})
+		})
+
+		Context("when release versions are overridden", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+				logger           *fakes.Logger
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+name: p-isolation-segment
+label: PCF Isolation Segment
+releases:
+- name: cf
+  file: cf-1.0.0.tgz
+  version: 1.0.0
+- name: routing
+  file: routing-0.170.tgz
+  version: "0.170"
+`})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+				logger = &fakes.Logger{}
+			})
+
+			It("updates the versions and warns that the tarballs are unchanged", func() {
+				err := replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+					Path:                    pathToTile,
+					Output:                  pathToOutputTile,
+					Name:                    "blue",
+					ReleaseVersionOverrides: map[string]string{"routing": "0.170.1"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(`---
+name: p-isolation-segment-blue
+label: PCF Isolation Segment (blue)
+releases:
+- name: cf
+  file: cf-1.0.0.tgz
+  version: 1.0.0
+- name: routing
+  file: routing-0.170.tgz
+  version: 0.170.1
+`))
+
+				format, v := logger.PrintfArgsForCall(1)
+				Expect(formatLogLine(format, v)).To(Equal("warning: set routing to version 0.170.1 in the metadata only, the release tarball is unchanged\n"))
+			})
+
+			It("returns an error for a release the tile does not have", func() {
+				err := replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+					Path:                    pathToTile,
+					Output:                  pathToOutputTile,
+					Name:                    "blue",
+					ReleaseVersionOverrides: map[string]string{"diego": "2.0.0"},
+				})
+				Expect(err).To(MatchError("cannot override the version of diego, the tile has no such release"))
+			})
+		})
+
+		Context("when property defaults mention the original name", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+name: p-isolation-segment
+label: PCF Isolation Segment
+property_blueprints:
+- name: system_domain
+  type: string
+  default: p-isolation-segment.example.com
+- name: products
+  type: string_list
+  default:
+  - p-isolation-segment
+  - cf
+- name: description
+  type: string
+  default: p-isolation-segment
+  configurable: true
+  label: p-isolation-segment
+- name: port
+  type: integer
+  default: 8080
+`})
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("clears them", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                 pathToTile,
+					Output:               pathToOutputTile,
+					Name:                 "blue",
+					OriginalNameDefaults: replicator.ClearOriginalNameDefaults,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(`---
+name: p-isolation-segment-blue
+label: PCF Isolation Segment (blue)
+property_blueprints:
+- name: system_domain
+  type: string
+- name: products
+  type: string_list
+- name: description
+  type: string
+  configurable: true
+  label: p-isolation-segment
+- name: port
+  type: integer
+  default: 8080
+`))
+			})
+
+			It("renames them", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                 pathToTile,
+					Output:               pathToOutputTile,
+					Name:                 "blue",
+					OriginalNameDefaults: replicator.RenameOriginalNameDefaults,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(`---
+name: p-isolation-segment-blue
+label: PCF Isolation Segment (blue)
+property_blueprints:
+- name: system_domain
+  type: string
+  default: p-isolation-segment-blue.example.com
+- name: products
+  type: string_list
+  default:
+  - p-isolation-segment-blue
+  - cf
+- name: description
+  type: string
+  default: p-isolation-segment-blue
+  configurable: true
+  label: p-isolation-segment
+- name: port
+  type: integer
+  default: 8080
+`))
+			})
+		})
+
+		Context("when WarnUnknownKeys is set", func() {
+			It("warns about top-level keys it does not recognize", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\nzero_downtime: true\nproduct_version: 1.0.0\nfeature_flags: {}\n"})
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger := &fakes.Logger{}
+				err = replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+					Path:            pathToTile,
+					Output:          pathToOutputTile,
+					Name:            "blue",
+					WarnUnknownKeys: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				format, v := logger.PrintfArgsForCall(1)
+				Expect(formatLogLine(format, v)).To(Equal("warning: metadata has unrecognized key feature_flags\n"))
+				format, v = logger.PrintfArgsForCall(2)
+				Expect(formatLogLine(format, v)).To(Equal("warning: metadata has unrecognized key zero_downtime\n"))
+				format, v = logger.PrintfArgsForCall(3)
+				Expect(formatLogLine(format, v)).To(HavePrefix("adding: "))
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("zero_downtime: true"))
+			})
+		})
+
+		Context("when a Timeout is set", func() {
+			It("stops and removes the partial output when it passes", func() {
+				entries := []tileEntry{{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"}}
+				for i := 0; i < 1000; i++ {
+					entries = append(entries, tileEntry{name: fmt.Sprintf("releases/release-%d.tgz", i), contents: strings.Repeat("x", 1024)})
+				}
+				pathToTile := writeTile(entries...)
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:    pathToTile,
+					Output:  pathToOutputTile,
+					Name:    "blue",
+					Timeout: time.Nanosecond,
+				})
+				Expect(err).To(MatchError("replication did not finish within 1ns"))
+
+				leftovers, err := ioutil.ReadDir(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(leftovers).To(BeEmpty())
+			})
+
+			It("stops in the middle of a large member", func() {
+				release := make([]byte, 2<<20)
+				rand.New(rand.NewSource(1)).Read(release)
+				pathToTile := writeTile(
+					tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+					tileEntry{name: "releases/some-release.tgz", contents: string(release)},
+				)
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				slept := false
+				var lastWritten int64
+				result, err := replicator.NewTileReplicator(&fakes.Logger{}).ReplicateWithResult(replicator.ApplicationConfig{
+					Path:           pathToTile,
+					Output:         pathToOutputTile,
+					Name:           "blue",
+					Quiet:          true,
+					Timeout:        50 * time.Millisecond,
+					CopyBufferSize: 4096,
+					OutputProgress: func(written int64) {
+						lastWritten = written
+						if written > 64<<10 && !slept {
+							slept = true
+							time.Sleep(100 * time.Millisecond)
+						}
+					},
+				})
+				Expect(err).To(MatchError("replication did not finish within 50ms"))
+				Expect(slept).To(BeTrue())
+				Expect(result.FilesCopied).To(Equal(1))
+				Expect(lastWritten).To(BeNumerically("<", 1<<20))
+			})
+		})
+
+		Context("when a renamed job name would be too long", func() {
+			var pathToOutputTile string
+
+			BeforeEach(func() {
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("returns an error before writing the tile", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   filepath.Join("..", "fixtures", "ist.pivotal"),
+					Output: pathToOutputTile,
+					Name:   strings.Repeat("a", 50),
+				})
+				Expect(err).To(MatchError("job name isolated_diego_cell_" + strings.Repeat("a", 50) + " is 70 characters, longer than the 64 allowed"))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+			})
+
+			It("uses MaxJobNameLength when it is set", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:             filepath.Join("..", "fixtures", "wrt-2016.pivotal"),
+					Output:           pathToOutputTile,
+					Name:             "blue",
+					MaxJobNameLength: 20,
+				})
+				Expect(err).To(MatchError("job name windows_diego_cell_blue is 23 characters, longer than the 20 allowed"))
+			})
+		})
+
+		Context("when GlobalSubstitutions are given", func() {
+			var pathToOutputTile string
+
+			BeforeEach(func() {
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("applies each substitution to the metadata", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\ndescription: deployed to ENV in REGION\n"})
 
-				for _, file := range outputTileReader.File {
-					Expect(file.Mode().String()).To(Equal(tilePermissions[file.Name]))
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+					GlobalSubstitutions: []replicator.Substitution{
+						{From: "ENV", To: "staging"},
+						{From: "REGION", To: "us-east-1"},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("description: deployed to staging in us-east-1"))
+			})
+
+			It("applies them in order after the built-in renames", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\ndescription: ENV\n"})
+
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+					GlobalSubstitutions: []replicator.Substitution{
+						{From: "p-isolation-segment-blue", To: "p-isolation-segment-custom"},
+						{From: "ENV", To: "REGION-staging"},
+						{From: "REGION", To: "us-east-1"},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+				Expect(contents).To(ContainSubstring("name: p-isolation-segment-custom"))
+				Expect(contents).To(ContainSubstring("description: us-east-1-staging"))
+			})
+		})
+
+		Context("when EmbedReplicationInfo is set", func() {
+			It("adds a replicated_from key describing the source tile", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\nproduct_version: 2.4.1\n"})
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                 pathToTile,
+					Output:               pathToOutputTile,
+					Name:                 "blue",
+					EmbedReplicationInfo: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				source, err := ioutil.ReadFile(pathToTile)
+				Expect(err).NotTo(HaveOccurred())
+				checksum := sha256.Sum256(source)
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(fmt.Sprintf(`label: PCF Isolation Segment (blue)
+name: p-isolation-segment-blue
+product_version: 2.4.1
+replicated_from:
+  checksum: %x
+  name: p-isolation-segment
+  source: %s
+  version: 2.4.1
+`, checksum, pathToTile)))
+			})
+
+			It("records the directory of a tile replicated from one", func() {
+				dir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.MkdirAll(filepath.Join(dir, "metadata"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(dir, "metadata", "p-isolation-segment.yml"), []byte("name: p-isolation-segment\nlabel: PCF Isolation Segment\n"), 0644)).To(Succeed())
+				pathToOutputTile := filepath.Join(dir, "replicated-tile.pivotal")
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).ReplicateFromDir(dir, replicator.ApplicationConfig{
+					Output:               pathToOutputTile,
+					Name:                 "blue",
+					EmbedReplicationInfo: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(fmt.Sprintf(`label: PCF Isolation Segment (blue)
+name: p-isolation-segment-blue
+replicated_from:
+  name: p-isolation-segment
+  source: %s
+`, dir)))
+			})
+		})
+
+		Context("when a FileFilter is given", func() {
+			It("skips the members it rejects but never the metadata", func() {
+				pathToTile := writeTile(
+					tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+					tileEntry{name: "releases/small.tgz", contents: "small"},
+					tileEntry{name: "releases/large.tgz", contents: strings.Repeat("x", 2048)},
+				)
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger := &fakes.Logger{}
+				err = replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+					FileFilter: func(name string, size int64) bool {
+						return size < 16
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tileFileNames(pathToOutputTile)).To(Equal([]string{"metadata/p-isolation-segment.yml", "releases/small.tgz"}))
+
+				format, v := logger.PrintfArgsForCall(1)
+				Expect(formatLogLine(format, v)).To(Equal("skipped: releases/large.tgz\n"))
+			})
+		})
+
+		Context("when AnnotateDescription is set", func() {
+			var pathToOutputTile string
+
+			BeforeEach(func() {
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("adds the name to the description", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\ndescription: Isolated routing and cells\n"})
+
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                pathToTile,
+					Output:              pathToOutputTile,
+					Name:                "blue",
+					AnnotateDescription: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("description: 'Isolated routing and cells (duplicate: blue)'"))
+			})
+
+			It("uses the DescriptionFunc when one is given", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\ndescription: Isolated routing and cells\n"})
+
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                pathToTile,
+					Output:              pathToOutputTile,
+					Name:                "blue",
+					AnnotateDescription: true,
+					DescriptionFunc: func(original string, config replicator.ApplicationConfig) string {
+						return strings.ToUpper(config.Name) + " " + original
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("description: BLUE Isolated routing and cells"))
+			})
+
+			It("does nothing when the tile has no description", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                filepath.Join("..", "fixtures", "ist.pivotal"),
+					Output:              pathToOutputTile,
+					Name:                "blue",
+					AnnotateDescription: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).NotTo(MatchRegexp(`(?m)^description:`))
+			})
+		})
+
+		Context("when RenameVMExtensions is set", func() {
+			It("suffixes the tile's VM extensions and the references to them", func() {
+				pathToTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+name: p-isolation-segment
+label: PCF Isolation Segment
+vm_extensions:
+- name: iso-lb
+  cloud_properties:
+    elbs: [iso-elb]
+job_types:
+- name: isolated_router
+  resource_definitions:
+  - name: ram
+    default: 1024
+  vm_extensions:
+  - iso-lb
+  - public_ip
+`})
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:               pathToTile,
+					Output:             pathToOutputTile,
+					Name:               "blue",
+					RenameVMExtensions: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(`---
+name: p-isolation-segment-blue
+label: PCF Isolation Segment (blue)
+vm_extensions:
+- name: iso-lb_blue
+  cloud_properties:
+    elbs: [iso-elb]
+job_types:
+- name: isolated_router_blue
+  resource_definitions:
+  - name: ram
+    default: 1024
+  vm_extensions:
+  - iso-lb_blue
+  - public_ip
+`))
+			})
+		})
+
+		Context("when CheckDeploymentName is set", func() {
+			var pathToOutputTile string
+
+			BeforeEach(func() {
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("returns an error when the product names normalize to the same deployment name", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   filepath.Join("..", "fixtures", "ist.pivotal"),
+					Output: pathToOutputTile,
+					Name:   "blue",
+					NameFunc: func(original string, config replicator.ApplicationConfig) (string, error) {
+						return "P_Isolation_Segment", nil
+					},
+					CheckDeploymentName: true,
+				})
+				Expect(err).To(MatchError("P_Isolation_Segment and p-isolation-segment would both deploy as p-isolation-segment"))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+			})
+
+			It("accepts distinct deployment names", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:                filepath.Join("..", "fixtures", "ist.pivotal"),
+					Output:              pathToOutputTile,
+					Name:                "blue",
+					CheckDeploymentName: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when StripComments is set", func() {
+			var pathToOutputTile string
+
+			BeforeEach(func() {
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("removes comment lines from the YAML members it rewrites", func() {
+				pathToTile := writeTile(
+					tileEntry{name: "metadata/p-isolation-segment.yml", contents: "# the product\nname: p-isolation-segment\nlabel: PCF Isolation Segment # shown in Ops Manager\n"},
+					tileEntry{name: "config/settings.yml", contents: "# settings for ENV\nenv: ENV\n  # nested comment\nnotes: |\n  # kept, part of the value\n  ENV notes\nurl: http://example.com/#ENV\n"},
+					tileEntry{name: "config/README", contents: "# ENV readme\n"},
+				)
+
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+					Replacements: []replicator.ReplacementRule{
+						{Old: "ENV", New: "staging", FileGlob: "config/*"},
+					},
+					StripComments: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).NotTo(ContainSubstring("#"))
+				Expect(readTileFile(pathToOutputTile, "config/settings.yml")).To(Equal("env: staging\nnotes: |\n  # kept, part of the value\n  staging notes\nurl: http://example.com/#staging\n"))
+				Expect(readTileFile(pathToOutputTile, "config/README")).To(Equal("# staging readme\n"))
+			})
+
+			It("keeps the comments when not set", func() {
+				pathToTile := writeTile(
+					tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"},
+					tileEntry{name: "config/settings.yml", contents: "# settings for ENV\nenv: ENV\n"},
+				)
+
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+					Replacements: []replicator.ReplacementRule{
+						{Old: "ENV", New: "staging", FileGlob: "config/*"},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "config/settings.yml")).To(Equal("# settings for staging\nenv: staging\n"))
+			})
+		})
+
+		Context("when a metadata key is lost", func() {
+			var (
+				pathToTile       string
+				pathToOutputTile string
+				dropRank         replicator.Transform
+			)
+
+			BeforeEach(func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: `---
+defaults: &defaults
+  rank: 90
+  serial: false
+<<: *defaults
+name: p-isolation-segment
+label: PCF Isolation Segment
+`})
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				dropRank = replicator.Transform{Name: "drop-rank", Apply: func(metadata map[string]interface{}, ctx replicator.TransformContext) error {
+					delete(metadata, "rank")
+					return nil
+				}}
+			})
+
+			It("keeps the keys a merge key brings in", func() {
+				logger := &fakes.Logger{}
+				err := replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+					Path:          pathToTile,
+					Output:        pathToOutputTile,
+					Name:          "blue",
+					FailOnKeyLoss: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(gomegamatchers.MatchYAML(`---
+defaults:
+  rank: 90
+  serial: false
+rank: 90
+serial: false
+name: p-isolation-segment-blue
+label: PCF Isolation Segment (blue)
+`))
+			})
+
+			It("warns about the missing key", func() {
+				logger := &fakes.Logger{}
+				err := replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{
+					Path:       pathToTile,
+					Output:     pathToOutputTile,
+					Name:       "blue",
+					Transforms: []replicator.Transform{dropRank},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				format, v := logger.PrintfArgsForCall(1)
+				Expect(formatLogLine(format, v)).To(Equal("warning: metadata key rank is missing after replication\n"))
+			})
+
+			It("returns an error with FailOnKeyLoss", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:          pathToTile,
+					Output:        pathToOutputTile,
+					Name:          "blue",
+					Transforms:    []replicator.Transform{dropRank},
+					FailOnKeyLoss: true,
+				})
+				Expect(err).To(MatchError("metadata keys rank are missing after replication"))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when a ProductNamePrefix is given", func() {
+			var pathToOutputTile string
+
+			BeforeEach(func() {
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+			})
+
+			It("replaces the leading segment of the product name", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:              filepath.Join("..", "fixtures", "ist.pivotal"),
+					Output:            pathToOutputTile,
+					Name:              "Blue Foo",
+					ProductNamePrefix: "myco",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+				Expect(contents).To(ContainSubstring("name: myco-isolation-segment-blue-foo"))
+				Expect(contents).To(ContainSubstring("isolated_diego_cell_blue_foo"))
+			})
+
+			It("keeps the original prefix without one", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:   filepath.Join("..", "fixtures", "ist.pivotal"),
+					Output: pathToOutputTile,
+					Name:   "Blue Foo",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(ContainSubstring("name: p-isolation-segment-blue-foo"))
+			})
+
+			It("returns an error when the product name would be invalid", func() {
+				err := replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+					Path:              filepath.Join("..", "fixtures", "ist.pivotal"),
+					Output:            pathToOutputTile,
+					Name:              "blue",
+					ProductNamePrefix: "My Co",
+				})
+				Expect(err).To(MatchError("product name My Co-isolation-segment-blue is not valid, it must be lowercase letters and digits separated by hyphens"))
+				Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when an OutputProgress callback is given", func() {
+			It("reports the increasing size of the output", func() {
+				var entries []tileEntry
+				entries = append(entries, tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"})
+				for i := 0; i < 20; i++ {
+					entries = append(entries, tileEntry{name: fmt.Sprintf("releases/release-%d.tgz", i), contents: fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Repeat("x", i))))})
+				}
+				pathToTile := writeTile(entries...)
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				var reported []int64
+				result, err := replicator.NewTileReplicator(&fakes.Logger{}).ReplicateWithResult(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "blue",
+					Quiet:  true,
+					OutputProgress: func(written int64) {
+						reported = append(reported, written)
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(len(reported)).To(BeNumerically(">", 1))
+				for i := 1; i < len(reported); i++ {
+					Expect(reported[i]).To(BeNumerically(">", reported[i-1]))
+				}
+				Expect(reported[len(reported)-1]).To(Equal(result.Size))
+			})
+		})
+
+		Context("when replicating the mongodb on-demand tile", func() {
+			BeforeEach(func() {
+				pathToTile = writeTile(
+					tileEntry{name: "metadata/"},
+					tileEntry{name: "metadata/mongodb-on-demand.yml", contents: `---
+name: mongodb-on-demand
+label: MongoDB Enterprise Service
+property_blueprints:
+- name: plan_collection
+  type: collection
+  default:
+  - plan_name: small
+  - plan_name: large
+job_types:
+- name: mongodb_broker
+  service_catalog:
+    plans:
+    - name: standalone
+    - name: replica_set
+runtime_configs:
+- name: mongodb-dns-aliases
+  runtime_config: |
+    releases:
+    - name: bosh-dns-aliases
+      version: 1.2.6
+`},
+				)
+
+				tempDir, err := ioutil.TempDir("", "")
+				Expect(err).NotTo(HaveOccurred())
+				pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+
+				logger = &fakes.Logger{}
+				tileReplicator = replicator.NewTileReplicator(logger)
+			})
+
+			It("warns when the runtime configs were already removed", func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/mongodb-on-demand.yml", contents: `---
+name: mongodb-on-demand
+label: MongoDB Enterprise Service
+job_types:
+- name: mongodb_broker
+runtime_configs: []
+`})
+
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Magenta Foo",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				format, v := logger.PrintfArgsForCall(1)
+				Expect(formatLogLine(format, v)).To(Equal("warning: " + pathToTile + " has no runtime configs to remove, they may already have been stripped\n"))
+				Expect(readTileFile(pathToOutputTile, "metadata/mongodb-on-demand.yml")).To(ContainSubstring("runtime_configs: []"))
+			})
+
+			It("warns that the runtime configs are removed", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Magenta Foo",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				format, v := logger.PrintfArgsForCall(1)
+				Expect(formatLogLine(format, v)).To(Equal("warning: removing the runtime configs of " + pathToTile + ", the duplicate tile requires the original tile to operate\n"))
+			})
+
+			It("does not warn when keeping the runtime configs", func() {
+				pathToTile = writeTile(tileEntry{name: "metadata/mongodb-on-demand.yml", contents: "name: mongodb-on-demand\nlabel: MongoDB Enterprise Service\nruntime_configs: []\n"})
+
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:               pathToTile,
+					Output:             pathToOutputTile,
+					Name:               "Magenta Foo",
+					KeepRuntimeConfigs: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				for i := 0; i < logger.PrintfCallCount(); i++ {
+					format, _ := logger.PrintfArgsForCall(i)
+					Expect(format).NotTo(ContainSubstring("runtime configs"))
 				}
 			})
+
+			It("suffixes the service plan names", func() {
+				err := tileReplicator.Replicate(replicator.ApplicationConfig{
+					Path:   pathToTile,
+					Output: pathToOutputTile,
+					Name:   "Magenta Foo",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				contents := readTileFile(pathToOutputTile, "metadata/mongodb-on-demand.yml")
+				Expect(contents).To(gomegamatchers.MatchYAML(`---
+name: mongodb-on-demand-magenta-foo
+label: MongoDB Enterprise Service (Magenta Foo)
+property_blueprints:
+- name: plan_collection
+  type: collection
+  default:
+  - plan_name: small_magenta_foo
+  - plan_name: large_magenta_foo
+job_types:
+- name: mongodb_broker_magenta_foo
+  service_catalog:
+    plans:
+    - name: standalone_magenta_foo
+    - name: replica_set_magenta_foo
+runtime_configs: []
+`))
+			})
+
+			Context("when FailOnDependentDuplicate is set", func() {
+				It("returns an error if the runtime configs would be removed", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:                     pathToTile,
+						Output:                   pathToOutputTile,
+						Name:                     "Magenta Foo",
+						FailOnDependentDuplicate: true,
+					})
+					Expect(err).To(MatchError("the duplicate of mongodb-on-demand would require the original tile to be installed"))
+					Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+				})
+
+				It("replicates when the runtime configs are kept", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:                     pathToTile,
+						Output:                   pathToOutputTile,
+						Name:                     "Magenta Foo",
+						KeepRuntimeConfigs:       true,
+						FailOnDependentDuplicate: true,
+					})
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("returns an error for a slim duplicate", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:                     filepath.Join("..", "fixtures", "ist.pivotal"),
+						Output:                   pathToOutputTile,
+						Name:                     "Magenta Foo",
+						SlimMode:                 true,
+						FailOnDependentDuplicate: true,
+					})
+					Expect(err).To(MatchError("the duplicate of p-isolation-segment would require the original tile to be installed"))
+				})
+			})
+
+			Context("when a job reference is not renamed", func() {
+				It("returns an error", func() {
+					pathToTile = writeTile(tileEntry{name: "metadata/mongodb-on-demand.yml", contents: `---
+name: mongodb-on-demand
+label: MongoDB Enterprise Service
+job_types:
+- name: mongodb_broker
+  templates:
+  - name: mongodb-dns-aliases
+    release: bosh-dns-aliases
+`})
+
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:   pathToTile,
+						Output: pathToOutputTile,
+						Name:   "Magenta Foo",
+					})
+
+					Expect(err).To(MatchError("metadata still references mongodb-dns-aliases after renaming it to mongodb-magenta_foo-dns-aliases"))
+				})
+			})
+
+			Context("when the runtime configs are kept", func() {
+				It("preserves the runtime configs", func() {
+					err := tileReplicator.Replicate(replicator.ApplicationConfig{
+						Path:               pathToTile,
+						Output:             pathToOutputTile,
+						Name:               "Magenta Foo",
+						KeepRuntimeConfigs: true,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					contents := readTileFile(pathToOutputTile, "metadata/mongodb-on-demand.yml")
+					Expect(contents).To(ContainSubstring(`runtime_configs:
+- name: mongodb-dns-aliases
+  runtime_config: |
+    releases:
+    - name: bosh-dns-aliases
+      version: 1.2.6
+`))
+				})
+			})
 		})
 	})
 })
@@ -0,0 +1,190 @@
+package replicator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The names of the built-in transforms.
+const (
+	TransformRenameJobs          = "rename-jobs"
+	TransformRenameDNS           = "rename-dns"
+	TransformStripRuntimeConfig  = "strip-runtime-config"
+	TransformRewriteVariables    = "rewrite-variables"
+	TransformOverlay             = "overlay"
+	TransformGlobalSubstitutions = "global-substitutions"
+)
+
+// TransformContext is what a Transform knows about the replication.
+// FormattedName is the name as it appears in job names.
+type TransformContext struct {
+	TileName      string
+	ProductName   string
+	FormattedName string
+	Config        ApplicationConfig
+}
+
+// Transform is one named step of the pipeline that rewrites a tile's
+// metadata. Apply changes metadata in place.
+type Transform struct {
+	Name  string
+	Apply func(metadata map[string]interface{}, ctx TransformContext) error
+}
+
+// BuiltinTransform returns the built-in transform called name, for composing
+// a pipeline in ApplicationConfig.Transforms.
+func BuiltinTransform(name string) (Transform, error) {
+	var tr TileReplicator
+
+	switch name {
+	case TransformRenameJobs:
+		return textTransform(name, func(metadata string, ctx TransformContext) string {
+			switch ctx.TileName {
+			case "p-isolation-segment":
+				return tr.replaceISTProperties(metadata, ctx.FormattedName, ctx.Config.RenameJobTypes)
+			case "p-windows-runtime", "pas-windows":
+				return tr.replaceWRTProperties(metadata, ctx.FormattedName)
+			case "mongodb-on-demand":
+				return strings.Replace(metadata, mongoDbJobType, fmt.Sprintf("%s_%s", mongoDbJobType, ctx.FormattedName), -1)
+			}
+			return metadata
+		}), nil
+	case TransformRenameDNS:
+		return textTransform(name, func(metadata string, ctx TransformContext) string {
+			return replaceMongoDbDNS(metadata, ctx.FormattedName)
+		}), nil
+	case TransformStripRuntimeConfig:
+		return textTransform(name, func(metadata string, ctx TransformContext) string {
+			return regexp.MustCompile(mongoRuntimeConfigReplaceRegex).ReplaceAllString(metadata, "runtime_configs: []")
+		}), nil
+	case TransformRewriteVariables:
+		return Transform{Name: name, Apply: func(metadata map[string]interface{}, ctx TransformContext) error {
+			tr.replaceVariableNames(metadata, ctx.FormattedName)
+			return nil
+		}}, nil
+	case TransformOverlay:
+		return Transform{Name: name, Apply: func(metadata map[string]interface{}, ctx TransformContext) error {
+			if ctx.Config.OverlayMetadataPath == "" {
+				return nil
+			}
+			return overlayMetadata(metadata, ctx.Config.OverlayMetadataPath)
+		}}, nil
+	case TransformGlobalSubstitutions:
+		return textTransform(name, func(metadata string, ctx TransformContext) string {
+			return applySubstitutions(metadata, ctx.Config.GlobalSubstitutions)
+		}), nil
+	}
+
+	return Transform{}, fmt.Errorf("unknown transform %s", name)
+}
+
+// DefaultTransforms returns the pipeline Replicate runs for tileName when
+// ApplicationConfig.Transforms is not set. It depends on the options in
+// config that the built-in transforms read. GlobalSubstitutions is not part
+// of it, since without a pipeline it is applied after the Replacements.
+func DefaultTransforms(tileName string, config ApplicationConfig) []Transform {
+	var names []string
+	if config.RenameVariables {
+		names = append(names, TransformRewriteVariables)
+	}
+	if config.OverlayMetadataPath != "" {
+		names = append(names, TransformOverlay)
+	}
+	if contains(supportedTiles, tileName) {
+		names = append(names, TransformRenameJobs)
+	}
+	if tileName == "mongodb-on-demand" {
+		names = append(names, TransformRenameDNS)
+		if !config.KeepRuntimeConfigs {
+			names = append(names, TransformStripRuntimeConfig)
+		}
+	}
+
+	return builtinTransforms(names)
+}
+
+// renamesJobs reports whether the pipeline renames the jobs, so the renames
+// can be checked. The default pipeline does.
+func renamesJobs(transforms []Transform) bool {
+	if transforms == nil {
+		return true
+	}
+
+	for _, transform := range transforms {
+		if transform.Name == TransformRenameJobs {
+			return true
+		}
+	}
+
+	return false
+}
+
+func builtinTransforms(names []string) []Transform {
+	var transforms []Transform
+	for _, name := range names {
+		transform, _ := BuiltinTransform(name)
+		transforms = append(transforms, transform)
+	}
+
+	return transforms
+}
+
+func runTransforms(metadata map[string]interface{}, transforms []Transform, ctx TransformContext) error {
+	for _, transform := range transforms {
+		err := transform.Apply(metadata, ctx)
+		if err != nil {
+			return fmt.Errorf("transform %s failed: %s", transform.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// textTransform makes a Transform of a rewrite of the marshaled metadata,
+// for the renames that are simplest as string replacements.
+func textTransform(name string, fn func(metadata string, ctx TransformContext) string) Transform {
+	return Transform{Name: name, Apply: func(metadata map[string]interface{}, ctx TransformContext) error {
+		contents, err := yaml.Marshal(metadata)
+		if err != nil {
+			return err // not tested
+		}
+
+		var replaced map[string]interface{}
+		err = yaml.Unmarshal([]byte(fn(string(contents), ctx)), &replaced)
+		if err != nil {
+			return err
+		}
+
+		for key := range metadata {
+			delete(metadata, key)
+		}
+		for key, value := range replaced {
+			metadata[key] = value
+		}
+
+		return nil
+	}}
+}
+
+func overlayMetadata(metadata map[string]interface{}, path string) error {
+	overlayContents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read overlay metadata: %s", err)
+	}
+
+	var overlay map[string]interface{}
+	err = yaml.Unmarshal(overlayContents, &overlay)
+	if err != nil {
+		return fmt.Errorf("could not parse overlay metadata: %s", err)
+	}
+
+	for key, value := range overlay {
+		metadata[key] = mergeMetadata(metadata[key], value)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package replicator_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("transforms", func() {
+	var (
+		tileReplicator   replicator.TileReplicator
+		pathToTile       string
+		pathToOutputTile string
+	)
+
+	BeforeEach(func() {
+		tileReplicator = replicator.NewTileReplicator(&fakes.Logger{})
+		pathToTile = filepath.Join("..", "fixtures", "ist.pivotal")
+
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile = filepath.Join(tempDir, "replicated-tile.pivotal")
+	})
+
+	builtin := func(name string) replicator.Transform {
+		transform, err := replicator.BuiltinTransform(name)
+		Expect(err).NotTo(HaveOccurred())
+		return transform
+	}
+
+	names := func(transforms []replicator.Transform) []string {
+		var names []string
+		for _, transform := range transforms {
+			names = append(names, transform.Name)
+		}
+		return names
+	}
+
+	Describe("DefaultTransforms", func() {
+		It("returns each tile type's pipeline", func() {
+			Expect(names(replicator.DefaultTransforms("p-isolation-segment", replicator.ApplicationConfig{}))).To(Equal([]string{"rename-jobs"}))
+			Expect(names(replicator.DefaultTransforms("mongodb-on-demand", replicator.ApplicationConfig{}))).To(Equal([]string{"rename-jobs", "rename-dns", "strip-runtime-config"}))
+			Expect(names(replicator.DefaultTransforms("mongodb-on-demand", replicator.ApplicationConfig{KeepRuntimeConfigs: true}))).To(Equal([]string{"rename-jobs", "rename-dns"}))
+			Expect(names(replicator.DefaultTransforms("p-isolation-segment", replicator.ApplicationConfig{
+				RenameVariables:     true,
+				OverlayMetadataPath: "overlay.yml",
+			}))).To(Equal([]string{"rewrite-variables", "overlay", "rename-jobs"}))
+		})
+
+		It("replicates as Replicate does without a pipeline", func() {
+			config := replicator.ApplicationConfig{
+				Path:   pathToTile,
+				Output: pathToOutputTile,
+				Name:   "blue",
+			}
+			Expect(tileReplicator.Replicate(config)).To(Succeed())
+			expected := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+
+			config.Transforms = replicator.DefaultTransforms("p-isolation-segment", config)
+			Expect(tileReplicator.Replicate(config)).To(Succeed())
+
+			Expect(readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")).To(Equal(expected))
+		})
+	})
+
+	Describe("a custom pipeline", func() {
+		It("runs the steps in order", func() {
+			err := tileReplicator.Replicate(replicator.ApplicationConfig{
+				Path:   pathToTile,
+				Output: pathToOutputTile,
+				Name:   "blue",
+				GlobalSubstitutions: []replicator.Substitution{
+					{From: "isolated_diego_cell", To: "isolated_diego_cell_blue"},
+				},
+				Transforms: []replicator.Transform{
+					{Name: "describe", Apply: func(metadata map[string]interface{}, ctx replicator.TransformContext) error {
+						metadata["description"] = "runs isolated_router for " + ctx.ProductName
+						return nil
+					}},
+					builtin("rename-jobs"),
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+			Expect(contents).To(ContainSubstring("description: runs isolated_router_blue for p-isolation-segment-blue"))
+			Expect(contents).NotTo(ContainSubstring("isolated_diego_cell_blue_blue"))
+		})
+
+		It("can leave a built-in step out", func() {
+			err := tileReplicator.Replicate(replicator.ApplicationConfig{
+				Path:   pathToTile,
+				Output: pathToOutputTile,
+				Name:   "blue",
+				Transforms: []replicator.Transform{
+					builtin("global-substitutions"),
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			contents := readTileFile(pathToOutputTile, "metadata/p-isolation-segment.yml")
+			Expect(contents).To(ContainSubstring("name: p-isolation-segment-blue"))
+			Expect(contents).To(ContainSubstring("isolated_diego_cell"))
+			Expect(contents).NotTo(ContainSubstring("isolated_diego_cell_blue"))
+		})
+
+		It("returns the error of a failing step", func() {
+			err := tileReplicator.Replicate(replicator.ApplicationConfig{
+				Path:   pathToTile,
+				Output: pathToOutputTile,
+				Name:   "blue",
+				Transforms: []replicator.Transform{
+					{Name: "fail", Apply: func(map[string]interface{}, replicator.TransformContext) error {
+						return errors.New("boom")
+					}},
+				},
+			})
+			Expect(err).To(MatchError("transform fail failed: boom"))
+			Expect(pathToOutputTile).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("BuiltinTransform", func() {
+		It("rejects unknown names", func() {
+			_, err := replicator.BuiltinTransform("rename-everything")
+			Expect(err).To(MatchError("unknown transform rename-everything"))
+		})
+	})
+})
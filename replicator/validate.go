@@ -0,0 +1,57 @@
+package replicator
+
+import (
+	"errors"
+	"strings"
+)
+
+// Validate reports every problem with the config at once, joined with "; ".
+// Replicate and the other entry points call it before doing anything else;
+// those that do not write config.Output do not require it.
+func (config ApplicationConfig) Validate() error {
+	return config.validate(true)
+}
+
+func (config ApplicationConfig) validate(requireOutput bool) error {
+	var problems []string
+
+	if config.Path == "" {
+		problems = append(problems, "path must not be empty")
+	}
+	if config.Name == "" && !config.AutoName {
+		problems = append(problems, "name must not be empty")
+	}
+	if config.Output == "" && requireOutput && !config.DryRun {
+		problems = append(problems, "output must not be empty")
+	}
+	if config.FoundationInName && config.Foundation == "" {
+		problems = append(problems, "foundation must be set to add it to the name")
+	}
+	if config.VerifyOutput && config.SkipOutputCheck {
+		problems = append(problems, "output cannot be both verified and left unchecked")
+	}
+	if config.ExpectedTileName != "" && len(config.AllowedTiles) != 0 && !contains(config.AllowedTiles, config.ExpectedTileName) {
+		problems = append(problems, "expected tile "+config.ExpectedTileName+" is not an allowed tile")
+	}
+	if config.Workers < 0 {
+		problems = append(problems, "workers must not be negative")
+	}
+	if config.Timeout < 0 {
+		problems = append(problems, "timeout must not be negative")
+	}
+	if config.ResumeFrom < 0 {
+		problems = append(problems, "resume from must not be negative")
+	}
+	if err := checkReplacements(config.Replacements); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := checkRenameJobTypes(config.RenameJobTypes); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package replicator_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("ApplicationConfig.Validate", func() {
+	var config replicator.ApplicationConfig
+
+	BeforeEach(func() {
+		config = replicator.ApplicationConfig{
+			Path:   "/some/tile/path",
+			Output: "/some/tile/output/path",
+			Name:   "blue",
+		}
+	})
+
+	It("accepts a coherent config", func() {
+		Expect(config.Validate()).To(Succeed())
+	})
+
+	It("requires a path, a name and an output", func() {
+		Expect(replicator.ApplicationConfig{}.Validate()).To(MatchError("path must not be empty; name must not be empty; output must not be empty"))
+	})
+
+	It("does not require a name with AutoName or an output for a dry run", func() {
+		config.Name = ""
+		config.AutoName = true
+		config.Output = ""
+		config.DryRun = true
+
+		Expect(config.Validate()).To(Succeed())
+	})
+
+	It("rejects options that cannot be used together", func() {
+		config.VerifyOutput = true
+		config.SkipOutputCheck = true
+		config.ExpectedTileName = "p-isolation-segment"
+		config.AllowedTiles = []string{"pas-windows"}
+
+		Expect(config.Validate()).To(MatchError("output cannot be both verified and left unchecked; " +
+			"expected tile p-isolation-segment is not an allowed tile"))
+	})
+
+	It("rejects FoundationInName without a Foundation", func() {
+		config.FoundationInName = true
+
+		Expect(config.Validate()).To(MatchError("foundation must be set to add it to the name"))
+	})
+
+	It("rejects negative workers and timeouts", func() {
+		config.Workers = -1
+		config.Timeout = -1
+
+		Expect(config.Validate()).To(MatchError("workers must not be negative; timeout must not be negative"))
+	})
+
+	It("rejects invalid replacement globs and unknown job types", func() {
+		config.Replacements = []replicator.ReplacementRule{{Old: "a", New: "b", FileGlob: "["}}
+		config.RenameJobTypes = []string{"some_job"}
+
+		err := config.Validate()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("invalid file glob [: "))
+		Expect(err.Error()).To(HaveSuffix("; cannot rename unknown job type some_job, renameable job types are [isolated_diego_cell isolated_ha_proxy isolated_router]"))
+	})
+
+	It("is checked before Replicate does anything", func() {
+		logger := &fakes.Logger{}
+
+		err := replicator.NewTileReplicator(logger).Replicate(replicator.ApplicationConfig{Name: "blue"})
+		Expect(err).To(MatchError("path must not be empty; output must not be empty"))
+		Expect(logger.PrintfCallCount()).To(Equal(0))
+	})
+})
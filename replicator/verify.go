@@ -0,0 +1,160 @@
+package replicator
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// VerifyOnlyMetadataChanged returns an error unless dstPath holds exactly
+// the members of srcPath, with identical CRCs and sizes for everything but
+// the metadata, and a metadata member that was rewritten.
+func VerifyOnlyMetadataChanged(srcPath, dstPath string) error {
+	return TileReplicator{}.VerifyOnlyMetadataChanged(srcPath, dstPath, ApplicationConfig{})
+}
+
+// VerifyOnlyMetadataChanged is the package function for a tile replicated
+// with config. The metadata is found, and the members are selected and
+// named, as Replicate does; external artifacts must match their files.
+func (t TileReplicator) VerifyOnlyMetadataChanged(srcPath, dstPath string, config ApplicationConfig) error {
+	srcTileZip, err := zip.OpenReader(srcPath)
+	if err != nil {
+		return errors.New("could not open source zip file")
+	}
+	defer srcTileZip.Close()
+
+	dstTileZip, err := zip.OpenReader(dstPath)
+	if err != nil {
+		return errors.New("could not open destination zip file")
+	}
+	defer dstTileZip.Close()
+
+	metadataMember, _, err := readMetadataFile(&srcTileZip.Reader, t.matcher(), config)
+	if err != nil {
+		return err
+	}
+
+	dstFiles := map[string]*zip.File{}
+	for _, dstFile := range dstTileZip.File {
+		dstFiles[dstFile.Name] = dstFile
+	}
+
+	for name, path := range config.IncludeExternalArtifacts {
+		dstName := t.destinationName(name, config)
+		dstFile, ok := dstFiles[dstName]
+		if !ok {
+			return fmt.Errorf("%s is missing from %s", dstName, dstPath)
+		}
+		delete(dstFiles, dstName)
+
+		if !fileMatches(dstFile, path) {
+			return fmt.Errorf("%s differs between %s and %s", dstName, path, dstPath)
+		}
+	}
+
+	files, _ := t.copiedFiles(&srcTileZip.Reader, metadataMember, config)
+	for _, srcFile := range files {
+		dstName := t.memberName(srcFile, config)
+		dstFile, ok := dstFiles[dstName]
+		if !ok {
+			return fmt.Errorf("%s is missing from %s", dstName, dstPath)
+		}
+		delete(dstFiles, dstName)
+
+		same := srcFile.CRC32 == dstFile.CRC32 && srcFile.UncompressedSize64 == dstFile.UncompressedSize64
+		if srcFile.Name == metadataMember {
+			if same {
+				return fmt.Errorf("metadata %s was not changed", srcFile.Name)
+			}
+			continue
+		}
+
+		if !same {
+			return fmt.Errorf("%s differs between %s and %s", srcFile.Name, srcPath, dstPath)
+		}
+	}
+
+	for _, dstFile := range dstTileZip.File {
+		if _, ok := dstFiles[dstFile.Name]; ok {
+			return fmt.Errorf("%s contains unexpected member %s", dstPath, dstFile.Name)
+		}
+	}
+
+	return nil
+}
+
+// VerifyArchive opens the zip at path and reads every member in full, so
+// that corrupt data or a CRC mismatch anywhere in the archive is reported.
+func VerifyArchive(path string) error {
+	tileZip, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %s", path, err)
+	}
+	defer tileZip.Close()
+
+	for _, file := range tileZip.File {
+		err = verifyMember(file)
+		if err != nil {
+			return fmt.Errorf("%s is corrupt in %s: %s", file.Name, path, err)
+		}
+	}
+
+	return nil
+}
+
+func verifyMember(file *zip.File) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(ioutil.Discard, reader)
+	return err
+}
+
+// checkOutput is a cheap sanity check of a written tile: it must open as a
+// zip and, if it has metadata, the metadata member must parse.
+func checkOutput(path, metadataMember string) error {
+	tileZip, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("output tile is invalid: %s", err)
+	}
+	defer tileZip.Close()
+
+	if metadataMember == "" {
+		return nil
+	}
+
+	for _, file := range tileZip.File {
+		if file.Name != metadataMember {
+			continue
+		}
+
+		contents, err := readZipFile(file)
+		if err != nil {
+			return fmt.Errorf("output tile is invalid: %s", err)
+		}
+
+		_, err = parseProductMetadata(contents)
+		if err != nil {
+			return fmt.Errorf("output tile metadata %s does not parse: %s", metadataMember, err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("output tile is missing metadata %s", metadataMember)
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,182 @@
+package replicator_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/dawu415/replicator/replicator"
+	"github.com/dawu415/replicator/replicator/fakes"
+)
+
+var _ = Describe("VerifyOnlyMetadataChanged", func() {
+	const metadata = "name: p-isolation-segment\nlabel: PCF Isolation Segment\n"
+
+	var pathToTile string
+
+	BeforeEach(func() {
+		pathToTile = writeTile(
+			tileEntry{name: "metadata/p-isolation-segment.yml", contents: metadata},
+			tileEntry{name: "releases/some-release.tgz", contents: "release"},
+		)
+	})
+
+	It("accepts a replicated tile", func() {
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+		err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+			Path:   pathToTile,
+			Output: pathToOutputTile,
+			Name:   "Magenta Foo",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(replicator.VerifyOnlyMetadataChanged(pathToTile, pathToOutputTile)).To(Succeed())
+	})
+
+	Context("when the tile was replicated with a config", func() {
+		It("finds and names the members as Replicate does", func() {
+			pathToTile = writeTile(
+				tileEntry{name: "product/tile.yml", contents: metadata},
+				tileEntry{name: "releases/"},
+				tileEntry{name: "releases/some-release.tgz", contents: "release"},
+			)
+			tempDir, err := ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+			pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+			config := replicator.ApplicationConfig{
+				Path:         pathToTile,
+				Output:       pathToOutputTile,
+				Name:         "Magenta Foo",
+				MetadataPath: "product/tile.yml",
+				PathPrefix:   "nested",
+			}
+			tileReplicator := replicator.NewTileReplicator(&fakes.Logger{})
+			Expect(tileReplicator.Replicate(config)).To(Succeed())
+
+			Expect(tileReplicator.VerifyOnlyMetadataChanged(pathToTile, pathToOutputTile, config)).To(Succeed())
+		})
+	})
+
+	Context("when a non-metadata member differs", func() {
+		It("returns an error", func() {
+			pathToOtherTile := writeTile(
+				tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment-magenta-foo\n"},
+				tileEntry{name: "releases/some-release.tgz", contents: "tampered"},
+			)
+
+			err := replicator.VerifyOnlyMetadataChanged(pathToTile, pathToOtherTile)
+			Expect(err).To(MatchError("releases/some-release.tgz differs between " + pathToTile + " and " + pathToOtherTile))
+		})
+	})
+
+	Context("when the metadata is unchanged", func() {
+		It("returns an error", func() {
+			err := replicator.VerifyOnlyMetadataChanged(pathToTile, pathToTile)
+			Expect(err).To(MatchError("metadata metadata/p-isolation-segment.yml was not changed"))
+		})
+	})
+
+	Context("when a member is missing", func() {
+		It("returns an error", func() {
+			pathToOtherTile := writeTile(tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment-magenta-foo\n"})
+
+			err := replicator.VerifyOnlyMetadataChanged(pathToTile, pathToOtherTile)
+			Expect(err).To(MatchError("releases/some-release.tgz is missing from " + pathToOtherTile))
+		})
+	})
+
+	Context("when the destination has an extra member", func() {
+		It("returns an error", func() {
+			pathToOtherTile := writeTile(
+				tileEntry{name: "metadata/p-isolation-segment.yml", contents: "name: p-isolation-segment-magenta-foo\n"},
+				tileEntry{name: "releases/some-release.tgz", contents: "release"},
+				tileEntry{name: "releases/extra.tgz", contents: "extra"},
+			)
+
+			err := replicator.VerifyOnlyMetadataChanged(pathToTile, pathToOtherTile)
+			Expect(err).To(MatchError(pathToOtherTile + " contains unexpected member releases/extra.tgz"))
+		})
+	})
+
+	Context("when a tile cannot be opened", func() {
+		It("returns an error", func() {
+			Expect(replicator.VerifyOnlyMetadataChanged("some-bogus-path", pathToTile)).To(MatchError("could not open source zip file"))
+			Expect(replicator.VerifyOnlyMetadataChanged(pathToTile, "some-bogus-path")).To(MatchError("could not open destination zip file"))
+		})
+	})
+})
+
+var _ = Describe("VerifyArchive", func() {
+	const contents = "some release contents that are easy to find"
+
+	var pathToTile string
+
+	BeforeEach(func() {
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToTile = filepath.Join(tempDir, "tile.pivotal")
+
+		f, err := os.Create(pathToTile)
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+
+		zw := zip.NewWriter(f)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "releases/some-release.tgz", Method: zip.Store})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = w.Write([]byte(contents))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zw.Close()).To(Succeed())
+	})
+
+	It("accepts an intact archive", func() {
+		Expect(replicator.VerifyArchive(pathToTile)).To(Succeed())
+	})
+
+	It("accepts a replicated tile", func() {
+		tempDir, err := ioutil.TempDir("", "")
+		Expect(err).NotTo(HaveOccurred())
+		pathToOutputTile := filepath.Join(tempDir, "replicated-tile.pivotal")
+
+		err = replicator.NewTileReplicator(&fakes.Logger{}).Replicate(replicator.ApplicationConfig{
+			Path:         filepath.Join("..", "fixtures", "ist.pivotal"),
+			Output:       pathToOutputTile,
+			Name:         "Magenta Foo",
+			VerifyOutput: true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(replicator.VerifyArchive(pathToOutputTile)).To(Succeed())
+	})
+
+	Context("when a member is corrupt", func() {
+		It("returns an error naming the member", func() {
+			archive, err := ioutil.ReadFile(pathToTile)
+			Expect(err).NotTo(HaveOccurred())
+
+			i := bytes.Index(archive, []byte(contents))
+			Expect(i).To(BeNumerically(">", 0))
+			archive[i] ^= 0xff
+			Expect(ioutil.WriteFile(pathToTile, archive, 0644)).To(Succeed())
+
+			err = replicator.VerifyArchive(pathToTile)
+			Expect(err).To(MatchError("releases/some-release.tgz is corrupt in " + pathToTile + ": zip: checksum error"))
+		})
+	})
+
+	Context("when the file is not a zip", func() {
+		It("returns an error", func() {
+			Expect(ioutil.WriteFile(pathToTile, []byte("not a zip"), 0644)).To(Succeed())
+
+			Expect(replicator.VerifyArchive(pathToTile)).To(MatchError(ContainSubstring("could not open " + pathToTile)))
+		})
+	})
+})